@@ -0,0 +1,213 @@
+package etcd
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/vine-io/vine/lib/cache"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// DefaultMaxTxnOps is the default number of operations in one etcd transaction,
+// it matches the default --max-txn-ops of the etcd server.
+var DefaultMaxTxnOps = 128
+
+// Result is the outcome of a single key of a bulk operation
+type Result struct {
+	Key string
+	// Record is the record found by MGet
+	Record *cache.Record
+	// Err is cache.ErrNotFound when MGet doesn't find the key, MDel treats
+	// a missing key as deleted like Del
+	Err error
+}
+
+// chunks calls fn for every range of items of n which fits in a transaction,
+// ops is the number of operations of an item.
+func (e *etcdCache) chunks(n, ops int, fn func(start, end int)) {
+	size := e.maxTxnOps
+	if size <= 0 {
+		size = DefaultMaxTxnOps
+	}
+	if size /= ops; size == 0 {
+		size = 1
+	}
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		fn(start, end)
+	}
+}
+
+func (e *etcdCache) MGet(ctx context.Context, keys []string, opts ...cache.GetOption) ([]*Result, error) {
+	options := cache.GetOptions{
+		Database: e.options.Database,
+		Table:    e.options.Table,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	pre := tablePrefix(options.Database, options.Table)
+	results := make([]*Result, len(keys))
+
+	var err error
+	e.chunks(len(keys), 1, func(start, end int) {
+		ops := make([]clientv3.Op, 0, end-start)
+		for _, key := range keys[start:end] {
+			ops = append(ops, clientv3.OpGet(path.Join(pre, key)))
+		}
+
+		rsp, e1 := e.client.Txn(ctx).Then(ops...).Commit()
+		for i, key := range keys[start:end] {
+			result := &Result{Key: key}
+			results[start+i] = result
+			if e1 != nil {
+				result.Err = e1
+				continue
+			}
+
+			kvs := rsp.Responses[i].GetResponseRange().GetKvs()
+			if len(kvs) == 0 {
+				result.Err = cache.ErrNotFound
+				continue
+			}
+			result.Record, result.Err = e.unmarshal(kvs[0].Value)
+		}
+		if e1 != nil && err == nil {
+			err = e1
+		}
+	})
+
+	return results, err
+}
+
+func (e *etcdCache) MPut(ctx context.Context, records []*cache.Record, opts ...cache.PutOption) ([]*Result, error) {
+	options := cache.PutOptions{
+		Database: e.options.Database,
+		Table:    e.options.Table,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	pre := tablePrefix(options.Database, options.Table)
+	results := make([]*Result, len(records))
+
+	var ttl time.Duration
+	if options.TTL != 0 {
+		ttl = options.TTL
+	} else if now := time.Now(); options.Expiry.After(now) {
+		ttl = options.Expiry.Sub(now)
+	}
+
+	var id clientv3.LeaseID
+	if ttl > 0 {
+		var err error
+		if id, err = e.leases.Acquire(ctx, ttl); err != nil {
+			return nil, err
+		}
+	}
+
+	// the fence keys are written along with the records
+	per := 1
+	if _, ok := tokenFromContext(ctx); ok {
+		per = 2
+	}
+
+	var err error
+	e.chunks(len(records), per, func(start, end int) {
+		keys := make([]string, 0, end-start)
+		vals := make([]string, 0, end-start)
+		for i, r := range records[start:end] {
+			if ttl > 0 {
+				r.Expiry = ttl
+			}
+			results[start+i] = &Result{Key: r.Key}
+			val, e1 := e.marshal(r)
+			if e1 != nil {
+				results[start+i].Err = e1
+				continue
+			}
+			keys = append(keys, path.Join(pre, r.Key))
+			vals = append(vals, val)
+		}
+		if len(keys) == 0 {
+			return
+		}
+
+		var e1 error
+		for retry := 0; ; retry++ {
+			ops := make([]clientv3.Op, 0, len(keys))
+			fences := make([]fence, 0, len(keys))
+			for i, key := range keys {
+				if ttl > 0 {
+					ops = append(ops, clientv3.OpPut(key, vals[i], clientv3.WithLease(id)))
+				} else {
+					ops = append(ops, clientv3.OpPut(key, vals[i]))
+				}
+				fences = append(fences, putFence(key, id))
+			}
+
+			_, e1 = e.commit(ctx, fences, ops...)
+			if e1 == rpctypes.ErrLeaseNotFound && retry == 0 {
+				// the shared lease was revoked, take a fresh one
+				e.leases.Invalidate(id)
+				if id, e1 = e.leases.Acquire(ctx, ttl); e1 == nil {
+					continue
+				}
+			}
+			break
+		}
+
+		for _, result := range results[start:end] {
+			if result.Err == nil {
+				result.Err = e1
+			}
+		}
+		if e1 != nil && err == nil {
+			err = e1
+		}
+	})
+
+	return results, err
+}
+
+func (e *etcdCache) MDel(ctx context.Context, keys []string, opts ...cache.DelOption) ([]*Result, error) {
+	options := cache.DelOptions{
+		Database: e.options.Database,
+		Table:    e.options.Table,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	pre := tablePrefix(options.Database, options.Table)
+	results := make([]*Result, len(keys))
+
+	// the fence keys are deleted along with the records
+	var err error
+	e.chunks(len(keys), 2, func(start, end int) {
+		ops := make([]clientv3.Op, 0, end-start)
+		fences := make([]fence, 0, end-start)
+		for _, key := range keys[start:end] {
+			key = path.Join(pre, key)
+			ops = append(ops, clientv3.OpDelete(key))
+			fences = append(fences, delFence(key))
+		}
+
+		_, e1 := e.commit(ctx, fences, ops...)
+		for i, key := range keys[start:end] {
+			results[start+i] = &Result{Key: key, Err: e1}
+		}
+		if e1 != nil && err == nil {
+			err = e1
+		}
+	})
+
+	return results, err
+}
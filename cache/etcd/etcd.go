@@ -5,11 +5,14 @@ import (
 	"crypto/tls"
 	"net"
 	"path"
+	"strings"
 	"time"
 
 	json "github.com/json-iterator/go"
 	"github.com/vine-io/vine/lib/cache"
 	"github.com/vine-io/vine/lib/cmd"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	"go.etcd.io/etcd/client/v3"
 )
 
@@ -18,15 +21,55 @@ func init() {
 }
 
 var (
-	_      cache.Cache = (*etcdCache)(nil)
-	prefix             = "/vine/cache"
+	_      Cache = (*etcdCache)(nil)
+	prefix       = "/vine/cache"
 )
 
+// scanPageSize is the number of keys fetched at once by List and prefixed Get
+const scanPageSize = 1000
+
+// Cache is the etcd implementation of cache.Cache with its extensions
+type Cache interface {
+	cache.Cache
+	// LeaseStats returns the state of the leases shared by TTL writes
+	LeaseStats() LeaseStats
+	// DelPrefix removes all records whose key starts with the given prefix and
+	// returns the number of removed records
+	DelPrefix(ctx context.Context, prefix string, opts ...cache.DelOption) (int64, error)
+	// MGet reads the records of the given keys in batches of transactions
+	MGet(ctx context.Context, keys []string, opts ...cache.GetOption) ([]*Result, error)
+	// MPut writes the records in batches of transactions
+	MPut(ctx context.Context, records []*cache.Record, opts ...cache.PutOption) ([]*Result, error)
+	// MDel removes the records of the given keys in batches of transactions
+	MDel(ctx context.Context, keys []string, opts ...cache.DelOption) ([]*Result, error)
+}
+
 type etcdCache struct {
 	client  *clientv3.Client
 	options cache.Options
+	leases  *leasePool
 
-	timeout time.Duration
+	transformer Transformer
+
+	timeout   time.Duration
+	maxTxnOps int
+}
+
+// tablePrefix returns the etcd key under which all records of the table are stored
+func tablePrefix(database, table string) string {
+	pre := prefix
+	if database != "" {
+		pre = path.Join(pre, database)
+	}
+	if table != "" {
+		pre = path.Join(pre, table)
+	}
+	return pre
+}
+
+// cacheKey returns the record key of the etcd key relative to the table prefix
+func cacheKey(pre, key string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(key, pre), "/")
 }
 
 func configure(e *etcdCache, client *clientv3.Client, opts ...cache.Option) error {
@@ -37,11 +80,27 @@ func configure(e *etcdCache, client *clientv3.Client, opts ...cache.Option) erro
 		o(&e.options)
 	}
 
+	granularity := DefaultLeaseGranularity
 	if e.options.Context != nil {
 		u, ok := e.options.Context.Value(timeKey{}).(*timeoutValue)
 		if ok {
 			e.timeout = u.duration
 		}
+
+		g, ok := e.options.Context.Value(leaseGranularityKey{}).(time.Duration)
+		if ok {
+			granularity = g
+		}
+
+		n, ok := e.options.Context.Value(maxTxnOpsKey{}).(int)
+		if ok {
+			e.maxTxnOps = n
+		}
+
+		t, ok := e.options.Context.Value(transformerKey{}).(Transformer)
+		if ok {
+			e.transformer = t
+		}
 	}
 
 	if client == nil {
@@ -93,6 +152,11 @@ func configure(e *etcdCache, client *clientv3.Client, opts ...cache.Option) erro
 		return err
 	}
 	e.client = client
+
+	if e.leases != nil {
+		e.leases.Close()
+	}
+	e.leases = newLeasePool(client, granularity)
 	return nil
 }
 
@@ -105,59 +169,73 @@ func (e *etcdCache) Options() cache.Options {
 }
 
 func (e *etcdCache) Get(ctx context.Context, key string, opts ...cache.GetOption) ([]*cache.Record, error) {
-	var options cache.GetOptions
+	options := cache.GetOptions{
+		Database: e.options.Database,
+		Table:    e.options.Table,
+	}
 	for _, o := range opts {
 		o(&options)
 	}
 
-	opOpts := make([]clientv3.OpOption, 0)
-	if options.Prefix {
-		opOpts = append(opOpts, clientv3.WithPrefix())
-	}
+	pre := tablePrefix(options.Database, options.Table)
 
-	pre := prefix
-	if options.Database != "" {
-		pre = path.Join(pre, options.Database)
+	if !options.Prefix && !options.Suffix {
+		rsp, err := e.client.Get(ctx, path.Join(pre, key))
+		if err != nil {
+			return nil, err
+		}
+
+		records := make([]*cache.Record, 0, len(rsp.Kvs))
+		for _, kv := range rsp.Kvs {
+			record, err := e.unmarshal(kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+		return records, nil
 	}
-	if options.Table != "" {
-		pre = path.Join(pre, options.Table)
+
+	from := pre + "/"
+	if options.Prefix {
+		from += key
 	}
-	if options.Limit != 0 {
-		opOpts = append(opOpts, clientv3.WithLimit(int64(options.Limit)))
+	match := func(k string) bool {
+		return !options.Suffix || strings.HasSuffix(k, key)
 	}
 
-	key = path.Join(pre, key)
-	rsp, err := e.client.Get(ctx, key, opOpts...)
+	var derr error
+	records := make([]*cache.Record, 0)
+	err := e.scan(ctx, from, false, options.Offset, options.Limit, match, func(kv *mvccpb.KeyValue) {
+		record, err := e.unmarshal(kv.Value)
+		if err != nil {
+			derr = err
+			return
+		}
+		records = append(records, record)
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	records := make([]*cache.Record, rsp.Count)
-	for i, kv := range rsp.Kvs {
-		record := cache.Record{}
-		_ = json.Unmarshal(kv.Value, &record)
-		records[i] = &record
+	if derr != nil {
+		return nil, derr
 	}
 
 	return records, nil
 }
 
 func (e *etcdCache) Put(ctx context.Context, r *cache.Record, opts ...cache.PutOption) error {
-	var options cache.PutOptions
+	options := cache.PutOptions{
+		Database: e.options.Database,
+		Table:    e.options.Table,
+	}
 	for _, o := range opts {
 		o(&options)
 	}
 
 	opOpts := make([]clientv3.OpOption, 0)
 
-	pre := prefix
-	if options.Database != "" {
-		pre = path.Join(pre, options.Database)
-	}
-	if options.Table != "" {
-		pre = path.Join(pre, options.Table)
-	}
-
+	var ttl time.Duration
 	if options.TTL != 0 || !options.Expiry.IsZero() {
 		now := time.Now()
 		if options.TTL != 0 {
@@ -165,86 +243,184 @@ func (e *etcdCache) Put(ctx context.Context, r *cache.Record, opts ...cache.PutO
 		} else if options.Expiry.After(now) {
 			r.Expiry = options.Expiry.Sub(now)
 		}
-		rsp, err := e.client.Grant(ctx, int64(r.Expiry.Seconds()))
-		if err != nil {
-			return err
-		}
-		opOpts = append(opOpts, clientv3.WithLease(rsp.ID))
+		ttl = r.Expiry
 	}
 
-	key := path.Join(pre, r.Key)
-	val, _ := json.Marshal(r)
-	_, err := e.client.Put(ctx, key, string(val), opOpts...)
+	key := path.Join(tablePrefix(options.Database, options.Table), r.Key)
+	val, err := e.marshal(r)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if ttl <= 0 {
+		_, err = e.commit(ctx, []fence{putFence(key, 0)}, clientv3.OpPut(key, val, opOpts...))
+		return err
+	}
+
+	for retry := 0; ; retry++ {
+		id, err := e.leases.Acquire(ctx, ttl)
+		if err != nil {
+			return err
+		}
+
+		_, err = e.commit(ctx, []fence{putFence(key, id)}, clientv3.OpPut(key, val, append(opOpts, clientv3.WithLease(id))...))
+		if err == rpctypes.ErrLeaseNotFound && retry == 0 {
+			// the shared lease was revoked, take a fresh one
+			e.leases.Invalidate(id)
+			continue
+		}
+		return err
+	}
 }
 
 func (e *etcdCache) Del(ctx context.Context, key string, opts ...cache.DelOption) error {
-	var options cache.DelOptions
+	options := cache.DelOptions{
+		Database: e.options.Database,
+		Table:    e.options.Table,
+	}
 	for _, o := range opts {
 		o(&options)
 	}
 
-	pre := prefix
-	opOpts := make([]clientv3.OpOption, 0)
+	key = path.Join(tablePrefix(options.Database, options.Table), key)
+	_, err := e.commit(ctx, []fence{delFence(key)}, clientv3.OpDelete(key))
+	return err
+}
 
-	if options.Database != "" {
-		pre = path.Join(pre, options.Database)
+func (e *etcdCache) DelPrefix(ctx context.Context, pre string, opts ...cache.DelOption) (int64, error) {
+	options := cache.DelOptions{
+		Database: e.options.Database,
+		Table:    e.options.Table,
 	}
-	if options.Table != "" {
-		pre = path.Join(pre, options.Table)
+	for _, o := range opts {
+		o(&options)
 	}
 
-	key = path.Join(pre, key)
-	_, err := e.client.Delete(ctx, key, opOpts...)
+	key := tablePrefix(options.Database, options.Table) + "/" + pre
+	rsp, err := e.commit(ctx, []fence{prefixFence(key)}, clientv3.OpDelete(key, clientv3.WithPrefix()))
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return rsp.Responses[0].GetResponseDeleteRange().GetDeleted(), nil
 }
 
 func (e *etcdCache) List(ctx context.Context, opts ...cache.ListOption) ([]string, error) {
-	var options cache.ListOptions
+	options := cache.ListOptions{
+		Database: e.options.Database,
+		Table:    e.options.Table,
+	}
 	for _, o := range opts {
 		o(&options)
 	}
 
-	key := prefix
-	opOpts := make([]clientv3.OpOption, 0)
-	opOpts = append(opOpts, clientv3.WithKeysOnly(), clientv3.WithPrefix())
+	pre := tablePrefix(options.Database, options.Table)
 
-	if options.Prefix != "" {
-		key = path.Join(key, options.Prefix)
+	match := func(k string) bool {
+		return strings.HasSuffix(k, options.Suffix)
 	}
 
-	if options.Database != "" {
-		key = path.Join(key, options.Database)
+	outs := make([]string, 0)
+	err := e.scan(ctx, pre+"/"+options.Prefix, true, options.Offset, options.Limit, match, func(kv *mvccpb.KeyValue) {
+		outs = append(outs, cacheKey(pre, string(kv.Key)))
+	})
+	if err != nil {
+		return nil, err
 	}
-	if options.Table != "" {
-		key = path.Join(key, options.Table)
+
+	return outs, nil
+}
+
+// scan walks the keys with the given prefix page by page from a single revision
+// and calls fn for the keys accepted by match, honoring offset and limit.
+func (e *etcdCache) scan(ctx context.Context, key string, keysOnly bool, offset, limit uint, match func(key string) bool, fn func(kv *mvccpb.KeyValue)) error {
+	end := clientv3.GetPrefixRangeEnd(key)
+
+	var rev int64
+	var matched uint
+	for {
+		opOpts := []clientv3.OpOption{
+			clientv3.WithRange(end),
+			clientv3.WithLimit(scanPageSize),
+			clientv3.WithRev(rev),
+		}
+		if keysOnly {
+			opOpts = append(opOpts, clientv3.WithKeysOnly())
+		}
+
+		rsp, err := e.client.Get(ctx, key, opOpts...)
+		if err != nil {
+			return err
+		}
+		rev = rsp.Header.Revision
+
+		for _, kv := range rsp.Kvs {
+			if !match(string(kv.Key)) {
+				continue
+			}
+			matched++
+			if matched <= offset {
+				continue
+			}
+			fn(kv)
+			if limit != 0 && matched-offset >= limit {
+				return nil
+			}
+		}
+
+		if !rsp.More || len(rsp.Kvs) == 0 {
+			return nil
+		}
+		key = string(rsp.Kvs[len(rsp.Kvs)-1].Key) + "\x00"
 	}
-	if options.Limit != 0 {
-		opOpts = append(opOpts, clientv3.WithLimit(int64(options.Limit)))
+}
+
+// marshal returns the record as stored in etcd
+func (e *etcdCache) marshal(r *cache.Record) (string, error) {
+	if e.transformer != nil {
+		v, err := e.transformer.Encode(r.Value)
+		if err != nil {
+			return "", err
+		}
+		record := *r
+		record.Value = v
+		r = &record
 	}
 
-	rsp, err := e.client.Get(ctx, key, opOpts...)
+	val, err := json.Marshal(r)
 	if err != nil {
+		return "", err
+	}
+	return string(val), nil
+}
+
+func (e *etcdCache) unmarshal(b []byte) (*cache.Record, error) {
+	record := &cache.Record{}
+	if err := json.Unmarshal(b, record); err != nil {
 		return nil, err
 	}
 
-	outs := make([]string, rsp.Count)
-	for i, kv := range rsp.Kvs {
-		outs[i] = string(kv.Key)
+	if e.transformer != nil {
+		v, err := e.transformer.Decode(record.Value)
+		if err != nil {
+			return nil, err
+		}
+		record.Value = v
 	}
+	return record, nil
+}
 
-	return outs, nil
+func (e *etcdCache) LeaseStats() LeaseStats {
+	if e.leases == nil {
+		return LeaseStats{}
+	}
+	return e.leases.Stats()
 }
 
 func (e *etcdCache) Close() error {
+	if e.leases != nil {
+		e.leases.Close()
+	}
 	if e.client == nil {
 		return nil
 	}
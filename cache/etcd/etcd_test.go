@@ -2,9 +2,12 @@ package etcd
 
 import (
 	"context"
+	gosync "sync"
 	"testing"
+	"time"
 
 	"github.com/vine-io/vine/lib/cache"
+	"go.etcd.io/etcd/client/v3"
 )
 
 var testCache cache.Cache
@@ -64,6 +67,14 @@ func Test_etcdCache_List(t *testing.T) {
 		t.Fatal("no record")
 	}
 	t.Log(records)
+
+	records, err = testCache.List(context.TODO(), cache.ListPrefix("rec"), cache.ListLimit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0] != "record" {
+		t.Fatalf("unexpected keys %v", records)
+	}
 }
 
 func Test_etcdCache_Del(t *testing.T) {
@@ -76,6 +87,141 @@ func Test_etcdCache_Del(t *testing.T) {
 	}
 }
 
+func Test_etcdCache_Batch(t *testing.T) {
+	if testCache == nil {
+		return
+	}
+	c := testCache.(Cache)
+
+	ctx := context.TODO()
+	records := []*cache.Record{
+		{Key: "batch1", Value: []byte("value1")},
+		{Key: "batch2", Value: []byte("value2")},
+	}
+	if _, err := c.MPut(ctx, records, cache.PutTo("db", "batch")); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := c.MGet(ctx, []string{"batch1", "batch2", "batch3"}, cache.GetFrom("db", "batch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Record == nil || results[1].Record == nil || results[2].Err != cache.ErrNotFound {
+		t.Fatalf("unexpected results %v", results)
+	}
+
+	if _, err = c.MDel(ctx, []string{"batch1"}, cache.DelFrom("db", "batch")); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.DelPrefix(ctx, "batch", cache.DelFrom("db", "batch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 deleted record, got %d", n)
+	}
+}
+
+func Test_etcdCache_BatchRevokedLease(t *testing.T) {
+	if testCache == nil {
+		return
+	}
+	c := testCache.(*etcdCache)
+
+	ctx := context.TODO()
+	id, err := c.leases.Acquire(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.client.Revoke(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	// the revoked shared lease is replaced
+	records := []*cache.Record{{Key: "revoked", Value: []byte("value")}}
+	results, err := c.MPut(ctx, records, cache.PutTTL(time.Minute), cache.PutTo("db", "revoked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil {
+		t.Fatal(results[0].Err)
+	}
+	if _, err = c.MDel(ctx, []string{"revoked"}, cache.DelFrom("db", "revoked")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_etcdCache_Fence(t *testing.T) {
+	if testCache == nil {
+		return
+	}
+	c := testCache.(*etcdCache)
+
+	ctx := context.TODO()
+	defer c.client.Delete(ctx, fencePrefix+"/db/fence/", clientv3.WithPrefix())
+
+	record := &cache.Record{Key: "fenced", Value: []byte("value")}
+	if err := c.Put(WithToken(ctx, 10), record, cache.PutTo("db", "fence")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(WithToken(ctx, 12), record, cache.PutTo("db", "fence")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(WithToken(ctx, 11), record, cache.PutTo("db", "fence")); err != ErrStaleToken {
+		t.Fatalf("expected stale token, got %v", err)
+	}
+	if err := c.Del(WithToken(ctx, 11), "fenced", cache.DelFrom("db", "fence")); err != ErrStaleToken {
+		t.Fatalf("expected stale token, got %v", err)
+	}
+	if err := c.Del(WithToken(ctx, 12), "fenced", cache.DelFrom("db", "fence")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the fence key shares the lease of the record and is deleted with it
+	if err := c.Put(WithToken(ctx, 13), record, cache.PutTo("db", "fence"), cache.PutTTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := c.client.Get(ctx, fencePrefix+"/db/fence/fenced")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Kvs) != 1 || rsp.Kvs[0].Lease == 0 {
+		t.Fatalf("expected the fence key with a lease, got %v", rsp.Kvs)
+	}
+	if err = c.Del(ctx, "fenced", cache.DelFrom("db", "fence")); err != nil {
+		t.Fatal(err)
+	}
+	if rsp, err = c.client.Get(ctx, fencePrefix+"/db/fence/fenced"); err != nil || len(rsp.Kvs) != 0 {
+		t.Fatalf("expected the fence key deleted, got %v: %v", rsp.Kvs, err)
+	}
+
+	// the bulk operations are fenced as well
+	records := []*cache.Record{{Key: "fenced1", Value: []byte("value")}, {Key: "fenced2", Value: []byte("value")}}
+	if _, err := c.MPut(WithToken(ctx, 20), records, cache.PutTo("db", "fence")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.MPut(WithToken(ctx, 19), records, cache.PutTo("db", "fence")); err != ErrStaleToken {
+		t.Fatalf("expected stale token, got %v", err)
+	}
+	if _, err := c.MDel(WithToken(ctx, 19), []string{"fenced1"}, cache.DelFrom("db", "fence")); err != ErrStaleToken {
+		t.Fatalf("expected stale token, got %v", err)
+	}
+	if _, err := c.DelPrefix(WithToken(ctx, 19), "fenced", cache.DelFrom("db", "fence")); err != ErrStaleToken {
+		t.Fatalf("expected stale token, got %v", err)
+	}
+	n, err := c.DelPrefix(WithToken(ctx, 20), "fenced", cache.DelFrom("db", "fence"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 deleted records, got %d", n)
+	}
+	if rsp, err = c.client.Get(ctx, fencePrefix+"/db/fence/", clientv3.WithPrefix()); err != nil || len(rsp.Kvs) != 0 {
+		t.Fatalf("expected the fence keys deleted, got %v: %v", rsp.Kvs, err)
+	}
+}
+
 func Test_etcdCache_Close(t *testing.T) {
 	if testCache == nil {
 		return
@@ -94,3 +240,114 @@ func Test_etcdCache_String(t *testing.T) {
 		t.Fatal("invalid string")
 	}
 }
+
+type testLease struct {
+	clientv3.Lease
+
+	mu     gosync.Mutex
+	grants []int64
+}
+
+func (l *testLease) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.grants = append(l.grants, ttl)
+	return &clientv3.LeaseGrantResponse{ID: clientv3.LeaseID(len(l.grants)), TTL: ttl}, nil
+}
+
+func Test_leasePool_Acquire(t *testing.T) {
+	lease := &testLease{}
+	pool := newLeasePool(lease, time.Minute)
+	defer pool.Close()
+
+	ctx := context.TODO()
+	first, err := pool.Acquire(ctx, time.Second*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := pool.Acquire(ctx, time.Second*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != next {
+		t.Fatalf("expected shared lease %d, got %d", first, next)
+	}
+
+	other, err := pool.Acquire(ctx, time.Minute*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Fatal("expected a new lease for another bucket")
+	}
+
+	if lease.grants[0] < 10 || lease.grants[1] < 300 {
+		t.Fatalf("lease expires earlier than requested: %v", lease.grants)
+	}
+
+	stats := pool.Stats()
+	if stats.Live != 2 || stats.Granted != 2 || stats.Reused != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	pool.Invalidate(first)
+	if stats = pool.Stats(); stats.Live != 1 {
+		t.Fatalf("expected one live lease, got %d", stats.Live)
+	}
+}
+
+func Test_etcdCache_chunks(t *testing.T) {
+	e := &etcdCache{maxTxnOps: 3}
+
+	var ranges [][2]int
+	e.chunks(7, 1, func(start, end int) {
+		ranges = append(ranges, [2]int{start, end})
+	})
+	if len(ranges) != 3 || ranges[0] != [2]int{0, 3} || ranges[2] != [2]int{6, 7} {
+		t.Fatalf("unexpected chunks %v", ranges)
+	}
+}
+
+func Test_tablePrefix(t *testing.T) {
+	pre := tablePrefix("db", "table")
+	if pre != "/vine/cache/db/table" {
+		t.Fatalf("unexpected prefix %s", pre)
+	}
+	if key := cacheKey(pre, pre+"/a/b"); key != "a/b" {
+		t.Fatalf("unexpected key %s", key)
+	}
+	if pre = tablePrefix("", ""); pre != prefix {
+		t.Fatalf("unexpected prefix %s", pre)
+	}
+}
+
+type testTransformer struct{}
+
+func (testTransformer) Encode(b []byte) ([]byte, error) {
+	return append([]byte("x"), b...), nil
+}
+
+func (testTransformer) Decode(b []byte) ([]byte, error) {
+	return b[1:], nil
+}
+
+func Test_etcdCache_transformer(t *testing.T) {
+	e := &etcdCache{transformer: testTransformer{}}
+
+	r := &cache.Record{Key: "key", Value: []byte("value")}
+	val, err := e.marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Value) != "value" {
+		t.Fatal("record is modified")
+	}
+
+	record, err := e.unmarshal([]byte(val))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(record.Value) != "value" {
+		t.Fatalf("unexpected value %s", record.Value)
+	}
+}
@@ -0,0 +1,140 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vine-io/vine/util/context/metadata"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// TokenHeader is the metadata key carrying the fencing token of a write, it
+// is the header set by the sync/etcd plugin for its locks and leaders.
+const TokenHeader = "Fencing-Token"
+
+// ErrStaleToken is returned when a write carries an older fencing token than
+// a write of the same record before
+var ErrStaleToken = errors.New("stale fencing token")
+
+// fencePrefix holds the highest fencing token accepted for every record. The
+// fence key of a record shares its lease and is deleted along with it.
+var fencePrefix = "/vine/fence"
+
+// fence is the fence key of a record written by commit, or of the records
+// under a prefix
+type fence struct {
+	key    string
+	prefix bool
+	// del is set when the record is deleted
+	del   bool
+	lease clientv3.LeaseID
+}
+
+// putFence returns the fence of the etcd key of a record put with the lease
+func putFence(key string, lease clientv3.LeaseID) fence {
+	return fence{key: fencePrefix + strings.TrimPrefix(key, prefix), lease: lease}
+}
+
+// delFence returns the fence of the etcd key of a deleted record
+func delFence(key string) fence {
+	return fence{key: fencePrefix + strings.TrimPrefix(key, prefix), del: true}
+}
+
+// prefixFence returns the fence of the records deleted under the etcd key
+func prefixFence(key string) fence {
+	return fence{key: fencePrefix + strings.TrimPrefix(key, prefix), prefix: true, del: true}
+}
+
+// op returns the operation on the fence key which stores the token val
+func (f fence) op(val string) clientv3.Op {
+	switch {
+	case f.prefix:
+		return clientv3.OpDelete(f.key, clientv3.WithPrefix())
+	case f.del:
+		return clientv3.OpDelete(f.key)
+	case f.lease != 0:
+		return clientv3.OpPut(f.key, val, clientv3.WithLease(f.lease))
+	default:
+		return clientv3.OpPut(f.key, val)
+	}
+}
+
+// WithToken attaches the fencing token to the context, the writes are rejected
+// when a newer token was used for the record before.
+func WithToken(ctx context.Context, token int64) context.Context {
+	return metadata.Set(ctx, TokenHeader, strconv.FormatInt(token, 10))
+}
+
+func tokenFromContext(ctx context.Context) (int64, bool) {
+	v, ok := metadata.Get(ctx, TokenHeader)
+	if !ok {
+		return 0, false
+	}
+	token, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return token, true
+}
+
+// commit applies the writes of the records in one transaction, fenced by the
+// token of the context. The transaction fails with ErrStaleToken when a newer
+// token was used for one of the records before, the fence keys of the records
+// store the token otherwise.
+func (e *etcdCache) commit(ctx context.Context, fences []fence, ops ...clientv3.Op) (*clientv3.TxnResponse, error) {
+	token, ok := tokenFromContext(ctx)
+	if !ok {
+		// the fence keys of deleted records are removed all the same
+		then := append([]clientv3.Op{}, ops...)
+		for _, f := range fences {
+			if f.del {
+				then = append(then, f.op(""))
+			}
+		}
+		return e.client.Txn(ctx).Then(then...).Commit()
+	}
+
+	// tokens are padded to compare the values as strings
+	val := fmt.Sprintf("%020d", token)
+	for {
+		gets := make([]clientv3.Op, 0, len(fences))
+		for _, f := range fences {
+			if f.prefix {
+				gets = append(gets, clientv3.OpGet(f.key, clientv3.WithPrefix()))
+			} else {
+				gets = append(gets, clientv3.OpGet(f.key))
+			}
+		}
+		rsp, err := e.client.Txn(ctx).Then(gets...).Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		cmps := make([]clientv3.Cmp, 0, len(fences))
+		then := append([]clientv3.Op{}, ops...)
+		for i, f := range fences {
+			for _, kv := range rsp.Responses[i].GetResponseRange().GetKvs() {
+				if string(kv.Value) > val {
+					return nil, ErrStaleToken
+				}
+			}
+
+			// the fence keys must not change until the write
+			cmp := clientv3.Compare(clientv3.ModRevision(f.key), "<", rsp.Header.Revision+1)
+			if f.prefix {
+				cmp = cmp.WithPrefix()
+			}
+			cmps = append(cmps, cmp)
+			then = append(then, f.op(val))
+		}
+
+		trsp, err := e.client.Txn(ctx).If(cmps...).Then(then...).Commit()
+		if err != nil || trsp.Succeeded {
+			return trsp, err
+		}
+		// another write changed a fence key, check its token
+	}
+}
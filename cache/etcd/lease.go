@@ -0,0 +1,189 @@
+package etcd
+
+import (
+	"context"
+	gosync "sync"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// DefaultLeaseGranularity is the default width of a lease bucket
+var DefaultLeaseGranularity = time.Second
+
+// LeaseStats describes the state of the lease pool
+type LeaseStats struct {
+	// Live is the number of leases which are not expired yet
+	Live int
+	// Granted is the total number of leases granted by the pool
+	Granted uint64
+	// Reused is the total number of writes which shared an existing lease
+	Reused uint64
+}
+
+type leaseBucket struct {
+	id clientv3.LeaseID
+	// expiry is the earliest time at which etcd may expire the lease
+	expiry time.Time
+	err    error
+	ready  chan struct{}
+}
+
+// leasePool shares one etcd lease between all records whose deadlines
+// fall into the same time bucket. The bucket is always rounded up, so
+// a record lives at most one granularity longer than requested and
+// never shorter.
+type leasePool struct {
+	lease       clientv3.Lease
+	granularity time.Duration
+
+	mu      gosync.Mutex
+	buckets map[int64]*leaseBucket
+	granted uint64
+	reused  uint64
+
+	exit chan struct{}
+	once gosync.Once
+}
+
+func newLeasePool(lease clientv3.Lease, granularity time.Duration) *leasePool {
+	if granularity < time.Second {
+		granularity = time.Second
+	}
+	p := &leasePool{
+		lease:       lease,
+		granularity: granularity,
+		buckets:     make(map[int64]*leaseBucket),
+		exit:        make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// bucket returns the end of the bucket the deadline falls into.
+func (p *leasePool) bucket(deadline time.Time) time.Time {
+	at := deadline.Truncate(p.granularity)
+	if at.Before(deadline) {
+		at = at.Add(p.granularity)
+	}
+	return at
+}
+
+// Acquire returns a lease which lives at least ttl.
+func (p *leasePool) Acquire(ctx context.Context, ttl time.Duration) (clientv3.LeaseID, error) {
+	now := time.Now()
+	deadline := now.Add(ttl)
+	at := p.bucket(deadline)
+
+	p.mu.Lock()
+	b, ok := p.buckets[at.UnixNano()]
+	if !ok {
+		b = &leaseBucket{ready: make(chan struct{})}
+		p.buckets[at.UnixNano()] = b
+		p.granted++
+		p.mu.Unlock()
+
+		p.grant(ctx, b, now, at)
+	} else {
+		p.reused++
+		p.mu.Unlock()
+	}
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-b.ready:
+	}
+
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.id, nil
+}
+
+// grant requests the lease of the bucket ending at the given time. The lease
+// is granted from now on, so it outlives every deadline within the bucket.
+func (p *leasePool) grant(ctx context.Context, b *leaseBucket, now, at time.Time) {
+	defer close(b.ready)
+
+	// etcd leases have a resolution of one second, round up
+	ttl := int64(at.Sub(now) / time.Second)
+	if now.Add(time.Duration(ttl) * time.Second).Before(at) {
+		ttl += 1
+	}
+
+	rsp, err := p.lease.Grant(ctx, ttl)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		b.err = err
+		if v, ok := p.buckets[at.UnixNano()]; ok && v == b {
+			delete(p.buckets, at.UnixNano())
+		}
+		return
+	}
+
+	b.id = rsp.ID
+	b.expiry = now.Add(time.Duration(rsp.TTL) * time.Second)
+}
+
+// Invalidate removes the bucket holding the given lease, e.g. when etcd
+// reports that the lease is gone.
+func (p *leasePool) Invalidate(id clientv3.LeaseID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k, b := range p.buckets {
+		if b.id == id {
+			delete(p.buckets, k)
+		}
+	}
+}
+
+// rotate drops the buckets whose lease has expired.
+func (p *leasePool) rotate(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k, b := range p.buckets {
+		if !b.expiry.IsZero() && !b.expiry.After(now) {
+			delete(p.buckets, k)
+		}
+	}
+}
+
+func (p *leasePool) run() {
+	ticker := time.NewTicker(p.granularity)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.exit:
+			return
+		case now := <-ticker.C:
+			p.rotate(now)
+		}
+	}
+}
+
+// Stats returns the current LeaseStats
+func (p *leasePool) Stats() LeaseStats {
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := LeaseStats{Granted: p.granted, Reused: p.reused}
+	for _, b := range p.buckets {
+		if b.expiry.After(now) {
+			stats.Live++
+		}
+	}
+	return stats
+}
+
+func (p *leasePool) Close() {
+	p.once.Do(func() {
+		close(p.exit)
+	})
+}
@@ -78,3 +78,51 @@ func TLS(cfg *tls.Config) cache.Option {
 		o.Context = context.WithValue(o.Context, tlsKey{}, &tlsValue{cfg: cfg})
 	}
 }
+
+type leaseGranularityKey struct{}
+
+// LeaseGranularity sets the width of the buckets used to share leases between
+// TTL writes. Expiry of records is rounded up to the granularity.
+func LeaseGranularity(d time.Duration) cache.Option {
+	return func(o *cache.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, leaseGranularityKey{}, d)
+	}
+}
+
+type maxTxnOpsKey struct{}
+
+// MaxTxnOps sets the maximum number of operations in one transaction of
+// the bulk operations, it must not exceed --max-txn-ops of the etcd server.
+func MaxTxnOps(n int) cache.Option {
+	return func(o *cache.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, maxTxnOpsKey{}, n)
+	}
+}
+
+// Transformer converts the record values on their way to and from etcd, the
+// transformers of the cache/transform plugin implement it.
+type Transformer interface {
+	// Encode is applied to the value before it is written
+	Encode(b []byte) ([]byte, error)
+	// Decode reverses Encode on the value read
+	Decode(b []byte) ([]byte, error)
+}
+
+type transformerKey struct{}
+
+// WithTransformer sets the Transformer of the record values, e.g. to compress
+// or encrypt them
+func WithTransformer(t Transformer) cache.Option {
+	return func(o *cache.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, transformerKey{}, t)
+	}
+}
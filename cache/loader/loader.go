@@ -0,0 +1,214 @@
+// Package loader provides a read-through cache.Cache loader with stampede protection
+package loader
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	gosync "sync"
+	"time"
+
+	"github.com/vine-io/vine/lib/cache"
+)
+
+// ErrLoading is returned by Refresh when another process loads the key
+var ErrLoading = errors.New("loading by another process")
+
+// LoadFunc loads the value of key from the source of truth
+type LoadFunc func(ctx context.Context, key string) ([]byte, error)
+
+// Loader reads values through a cache.Cache. Concurrent loads of a key are
+// deduplicated within the process and, with a Locker, across processes.
+// Values past their TTL are served for StaleTTL while one caller refreshes them.
+type Loader struct {
+	c    cache.Cache
+	opts Options
+
+	mu    gosync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg  gosync.WaitGroup
+	val []byte
+	err error
+}
+
+// entry is a cached value with the time it is fresh until
+type entry struct {
+	value []byte
+	fresh time.Time
+}
+
+func New(c cache.Cache, opts ...Option) *Loader {
+	return &Loader{
+		c:     c,
+		opts:  newOptions(opts...),
+		calls: make(map[string]*call),
+	}
+}
+
+func (l *Loader) Options() Options {
+	return l.opts
+}
+
+// Get returns the value of key, fn is called when the value isn't cached.
+func (l *Loader) Get(ctx context.Context, key string, fn LoadFunc) ([]byte, error) {
+	e, err := l.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if e != nil {
+		if time.Now().Before(e.fresh) {
+			return e.value, nil
+		}
+
+		// serve the stale value, one caller refreshes it
+		l.mu.Lock()
+		_, loading := l.calls[key]
+		l.mu.Unlock()
+		if loading {
+			return e.value, nil
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), l.opts.RefreshTimeout)
+			defer cancel()
+			_, _ = l.do(ctx, key, fn, false)
+		}()
+		return e.value, nil
+	}
+
+	return l.do(ctx, key, fn, true)
+}
+
+// Refresh loads the value of key and writes it to the cache
+func (l *Loader) Refresh(ctx context.Context, key string, fn LoadFunc) ([]byte, error) {
+	return l.do(ctx, key, fn, false)
+}
+
+// do runs load once per key in this process, concurrent callers share the result.
+// A panic of fn is returned as an error to all of them.
+func (l *Loader) do(ctx context.Context, key string, fn LoadFunc, wait bool) (val []byte, err error) {
+	l.mu.Lock()
+	if c, ok := l.calls[key]; ok {
+		l.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := new(call)
+	c.wg.Add(1)
+	l.calls[key] = c
+	l.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.val, c.err = nil, fmt.Errorf("load of %s panicked: %v", key, r)
+		}
+		c.wg.Done()
+
+		l.mu.Lock()
+		delete(l.calls, key)
+		l.mu.Unlock()
+
+		val, err = c.val, c.err
+	}()
+
+	c.val, c.err = l.load(ctx, key, fn, wait)
+	return c.val, c.err
+}
+
+func (l *Loader) load(ctx context.Context, key string, fn LoadFunc, wait bool) ([]byte, error) {
+	if l.opts.Locker != nil {
+		unlock, ok, err := l.opts.Locker.TryLock(ctx, l.opts.LockPrefix+key, l.opts.LockTTL)
+		switch {
+		case err != nil:
+			return nil, err
+		case ok:
+			defer unlock()
+		case !wait:
+			// another process refreshes the value
+			return nil, ErrLoading
+		default:
+			if v, ok := l.poll(ctx, key); ok {
+				return v, nil
+			}
+			// the other process didn't finish in time, load it anyway
+		}
+	}
+
+	v, err := fn(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return v, l.put(ctx, key, v)
+}
+
+// poll waits for the value loaded by another process until the lock expires
+func (l *Loader) poll(ctx context.Context, key string) ([]byte, bool) {
+	timer := time.NewTimer(l.opts.LockTTL)
+	defer timer.Stop()
+	ticker := time.NewTicker(l.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-timer.C:
+			return nil, false
+		case <-ticker.C:
+			e, err := l.get(ctx, key)
+			if err == nil && e != nil && time.Now().Before(e.fresh) {
+				return e.value, true
+			}
+		}
+	}
+}
+
+func (l *Loader) get(ctx context.Context, key string) (*entry, error) {
+	records, err := l.c.Get(ctx, key, l.opts.getOptions()...)
+	if errors.Is(err, cache.ErrNotFound) || (err == nil && len(records) == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e, ok := decode(records[0].Value)
+	if !ok {
+		return nil, nil
+	}
+	return e, nil
+}
+
+func (l *Loader) put(ctx context.Context, key string, v []byte) error {
+	r := &cache.Record{
+		Key:    key,
+		Value:  encode(&entry{value: v, fresh: time.Now().Add(l.opts.TTL)}),
+		Expiry: l.opts.TTL + l.opts.StaleTTL,
+	}
+	return l.c.Put(ctx, r, l.opts.putOptions()...)
+}
+
+// encode prepends the fresh time to the value, so that it survives caches
+// which don't keep record metadata.
+func encode(e *entry) []byte {
+	b := make([]byte, 8+len(e.value))
+	binary.BigEndian.PutUint64(b, uint64(e.fresh.UnixNano()))
+	copy(b[8:], e.value)
+	return b
+}
+
+func decode(b []byte) (*entry, bool) {
+	if len(b) < 8 {
+		return nil, false
+	}
+	return &entry{
+		value: b[8:],
+		fresh: time.Unix(0, int64(binary.BigEndian.Uint64(b))),
+	}, true
+}
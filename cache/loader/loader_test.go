@@ -0,0 +1,189 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	gosync "sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vine-io/vine/lib/cache/memory"
+	"github.com/vine-io/vine/lib/sync"
+)
+
+func TestLoader_Get(t *testing.T) {
+	l := New(memory.NewCache(), TTL(time.Minute))
+
+	var loads int32
+	fn := func(ctx context.Context, key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(time.Millisecond * 50)
+		return []byte("value"), nil
+	}
+
+	ctx := context.TODO()
+	var wg gosync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := l.Get(ctx, "key", fn)
+			if err != nil || string(v) != "value" {
+				t.Errorf("unexpected value %s: %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := l.Get(ctx, "key", fn); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("expected 1 load, got %d", n)
+	}
+}
+
+func TestLoader_Panic(t *testing.T) {
+	l := New(memory.NewCache(), TTL(time.Minute))
+
+	ctx := context.TODO()
+	_, err := l.Get(ctx, "key", func(ctx context.Context, key string) ([]byte, error) {
+		panic("load failed")
+	})
+	if err == nil {
+		t.Fatal("expected the panic as error")
+	}
+
+	// the failed load doesn't block the next one
+	v, err := l.Get(ctx, "key", func(ctx context.Context, key string) ([]byte, error) {
+		return []byte("value"), nil
+	})
+	if err != nil || string(v) != "value" {
+		t.Fatalf("unexpected value %s: %v", v, err)
+	}
+}
+
+func TestLoader_Stale(t *testing.T) {
+	l := New(memory.NewCache(), TTL(time.Millisecond*50), StaleTTL(time.Minute), RefreshTimeout(time.Hour))
+
+	var loads int32
+	fn := func(ctx context.Context, key string) ([]byte, error) {
+		if atomic.AddInt32(&loads, 1) == 1 {
+			return []byte("old"), nil
+		}
+		// the refresh is bounded by RefreshTimeout, not by the LockTTL
+		if d, ok := ctx.Deadline(); !ok || time.Until(d) < time.Minute {
+			return nil, errors.New("unexpected refresh deadline")
+		}
+		return []byte("new"), nil
+	}
+
+	ctx := context.TODO()
+	if _, err := l.Get(ctx, "key", fn); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	v, err := l.Get(ctx, "key", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "old" {
+		t.Fatalf("expected stale value, got %s", v)
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	v, _ = l.Get(ctx, "key", fn)
+	if string(v) != "new" {
+		t.Fatalf("expected refreshed value, got %s", v)
+	}
+}
+
+type testLocker struct{}
+
+func (testLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	return nil, false, nil
+}
+
+func TestLoader_Locked(t *testing.T) {
+	c := memory.NewCache()
+	l := New(c, WithLocker(testLocker{}), LockTTL(time.Second), PollInterval(time.Millisecond*10))
+	other := New(c)
+
+	ctx := context.TODO()
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_, _ = other.Refresh(ctx, "key", func(ctx context.Context, key string) ([]byte, error) {
+			return []byte("other"), nil
+		})
+	}()
+
+	v, err := l.Get(ctx, "key", func(ctx context.Context, key string) ([]byte, error) {
+		return []byte("self"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "other" {
+		t.Fatalf("expected value of the lock holder, got %s", v)
+	}
+}
+
+// testSync holds one lock, Lock waits for it as long as the LockWait
+type testSync struct {
+	sync.Sync
+	held chan struct{}
+}
+
+func (s *testSync) Lock(ctx context.Context, id string, opts ...sync.LockOption) error {
+	var options sync.LockOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	var timeout <-chan time.Time
+	if options.Wait > 0 {
+		timeout = time.After(options.Wait)
+	}
+	select {
+	case s.held <- struct{}{}:
+		return nil
+	case <-timeout:
+		return sync.ErrLockTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *testSync) Unlock(ctx context.Context, id string) error {
+	<-s.held
+	return nil
+}
+
+func TestSyncLocker(t *testing.T) {
+	s := &testSync{held: make(chan struct{}, 1)}
+	l := SyncLocker(s, 0)
+
+	ctx := context.TODO()
+	if err := s.Lock(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the held lock is not waited for
+	start := time.Now()
+	if _, ok, err := l.TryLock(ctx, "key", time.Second); err != nil || ok {
+		t.Fatalf("expected held lock, got %v %v", ok, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("waited %v for the held lock", d)
+	}
+
+	if err := s.Unlock(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	unlock, ok, err := l.TryLock(ctx, "key", time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected lock, got %v %v", ok, err)
+	}
+	unlock()
+}
@@ -0,0 +1,49 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vine-io/vine/lib/sync"
+)
+
+// Locker is a short lived distributed lock used to deduplicate loads across processes
+type Locker interface {
+	// TryLock acquires the lock without waiting for other holders, ok is false
+	// when the lock is held by another process.
+	TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(), ok bool, err error)
+}
+
+// DefaultTryWait is the time SyncLocker spends to acquire a lock when no wait
+// is given, sync.Sync has no attempt which returns at once.
+var DefaultTryWait = time.Millisecond * 100
+
+type syncLocker struct {
+	s    sync.Sync
+	wait time.Duration
+}
+
+// SyncLocker returns a Locker on top of a sync.Sync, e.g. the sync/etcd plugin.
+// wait bounds the time spent to acquire the lock, DefaultTryWait when it is 0.
+func SyncLocker(s sync.Sync, wait time.Duration) Locker {
+	if wait <= 0 {
+		wait = DefaultTryWait
+	}
+	return &syncLocker{s: s, wait: wait}
+}
+
+func (l *syncLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	err := l.s.Lock(ctx, key, sync.LockTTL(ttl), sync.LockWait(l.wait))
+	if err == sync.ErrLockTimeout || (errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil) {
+		// held by another process
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return func() {
+		_ = l.s.Unlock(context.Background(), key)
+	}, true, nil
+}
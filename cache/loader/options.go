@@ -0,0 +1,114 @@
+package loader
+
+import (
+	"time"
+
+	"github.com/vine-io/vine/lib/cache"
+)
+
+type Options struct {
+	// TTL is the time a loaded value is fresh
+	TTL time.Duration
+	// StaleTTL is the time a value is served after TTL while it is refreshed in background
+	StaleTTL time.Duration
+	// Locker deduplicates loads across processes
+	Locker Locker
+	// LockTTL is the time the lock is held by a loading process
+	LockTTL time.Duration
+	// LockPrefix is prepended to the key of the lock
+	LockPrefix string
+	// PollInterval is the interval at which the cache is polled while another process loads
+	PollInterval time.Duration
+	// RefreshTimeout bounds the background refresh of a stale value
+	RefreshTimeout time.Duration
+
+	Database, Table string
+}
+
+type Option func(o *Options)
+
+// TTL sets the time a loaded value is fresh
+func TTL(d time.Duration) Option {
+	return func(o *Options) {
+		o.TTL = d
+	}
+}
+
+// StaleTTL sets the time an expired value is still served while one caller refreshes it
+func StaleTTL(d time.Duration) Option {
+	return func(o *Options) {
+		o.StaleTTL = d
+	}
+}
+
+// WithLocker sets the Locker deduplicating loads across processes
+func WithLocker(l Locker) Option {
+	return func(o *Options) {
+		o.Locker = l
+	}
+}
+
+// LockTTL sets the time the lock is held by a loading process
+func LockTTL(d time.Duration) Option {
+	return func(o *Options) {
+		o.LockTTL = d
+	}
+}
+
+// LockPrefix sets the prefix of the lock keys
+func LockPrefix(p string) Option {
+	return func(o *Options) {
+		o.LockPrefix = p
+	}
+}
+
+// PollInterval sets the interval to poll the cache while another process loads
+func PollInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.PollInterval = d
+	}
+}
+
+// RefreshTimeout sets the time the background refresh of a stale value may take
+func RefreshTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.RefreshTimeout = d
+	}
+}
+
+// Table sets the database and table of the cached values
+func Table(database, table string) Option {
+	return func(o *Options) {
+		o.Database = database
+		o.Table = table
+	}
+}
+
+func newOptions(opts ...Option) Options {
+	options := Options{
+		TTL:            time.Minute,
+		LockTTL:        time.Second * 5,
+		LockPrefix:     "loader/",
+		PollInterval:   time.Millisecond * 50,
+		RefreshTimeout: time.Second * 30,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	return options
+}
+
+func (o Options) getOptions() []cache.GetOption {
+	if o.Database == "" && o.Table == "" {
+		return nil
+	}
+	return []cache.GetOption{cache.GetFrom(o.Database, o.Table)}
+}
+
+func (o Options) putOptions() []cache.PutOption {
+	opts := []cache.PutOption{cache.PutTTL(o.TTL + o.StaleTTL)}
+	if o.Database != "" || o.Table != "" {
+		opts = append(opts, cache.PutTo(o.Database, o.Table))
+	}
+	return opts
+}
@@ -0,0 +1,135 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vine-io/vine/lib/cache"
+)
+
+// Result is the outcome of a single key of a bulk operation
+type Result struct {
+	Key string
+	// Record is the record found by MGet
+	Record *cache.Record
+	// Err is cache.ErrNotFound when MGet doesn't find the key, MDel treats
+	// a missing key as deleted like Del
+	Err error
+}
+
+func (r *rkv) MGet(ctx context.Context, keys []string, opts ...cache.GetOption) ([]*Result, error) {
+	options := cache.GetOptions{}
+	options.Table = r.options.Table
+
+	for _, o := range opts {
+		o(&options)
+	}
+
+	gets := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	_, err := r.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			rkey := fmt.Sprintf("%s%s", options.Table, key)
+			gets[i] = pipe.Get(ctx, rkey)
+			ttls[i] = pipe.TTL(ctx, rkey)
+		}
+		return nil
+	})
+	if err == redis.Nil {
+		err = nil
+	}
+
+	results := make([]*Result, len(keys))
+	for i, key := range keys {
+		result := &Result{Key: key}
+		results[i] = result
+
+		val, e1 := gets[i].Bytes()
+		if e1 == redis.Nil {
+			result.Err = cache.ErrNotFound
+			continue
+		} else if e1 != nil {
+			result.Err = e1
+			continue
+		}
+
+		d, e1 := ttls[i].Result()
+		if e1 != nil {
+			result.Err = e1
+			continue
+		}
+
+		if val, result.Err = r.decode(val); result.Err != nil {
+			continue
+		}
+
+		result.Record = &cache.Record{
+			Key:    key,
+			Value:  val,
+			Expiry: d,
+		}
+	}
+
+	return results, err
+}
+
+func (r *rkv) MPut(ctx context.Context, records []*cache.Record, opts ...cache.PutOption) ([]*Result, error) {
+	options := cache.PutOptions{}
+	options.Table = r.options.Table
+
+	for _, o := range opts {
+		o(&options)
+	}
+
+	results := make([]*Result, len(records))
+	sets := make([]*redis.StatusCmd, len(records))
+	_, err := r.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, record := range records {
+			results[i] = &Result{Key: record.Key}
+			val, err := r.encode(record.Value)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			rkey := fmt.Sprintf("%s%s", options.Table, record.Key)
+			sets[i] = pipe.Set(ctx, rkey, val, record.Expiry)
+		}
+		return nil
+	})
+
+	for i, set := range sets {
+		if set != nil {
+			results[i].Err = set.Err()
+		}
+	}
+
+	return results, err
+}
+
+func (r *rkv) MDel(ctx context.Context, keys []string, opts ...cache.DelOption) ([]*Result, error) {
+	options := cache.DelOptions{}
+	options.Table = r.options.Table
+
+	for _, o := range opts {
+		o(&options)
+	}
+
+	dels := make([]*redis.IntCmd, len(keys))
+	_, err := r.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			rkey := fmt.Sprintf("%s%s", options.Table, key)
+			dels[i] = pipe.Del(ctx, rkey)
+		}
+		return nil
+	})
+
+	results := make([]*Result, len(keys))
+	for i, key := range keys {
+		result := &Result{Key: key}
+		result.Err = dels[i].Err()
+		results[i] = result
+	}
+
+	return results, err
+}
@@ -0,0 +1,41 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// unlockScript deletes the lock only when it is still owned by the token
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// Locker is a short lived lock on top of SET NX, e.g. to deduplicate
+// loads of a key across processes.
+type Locker struct {
+	Client *redis.Client
+}
+
+func NewLocker(client *redis.Client) *Locker {
+	return &Locker{Client: client}
+}
+
+// TryLock acquires the lock of key for ttl without waiting, ok is false when
+// the lock is held by another owner.
+func (l *Locker) TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(), ok bool, err error) {
+	token := uuid.New().String()
+	ok, err = l.Client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	return func() {
+		_ = unlockScript.Run(context.Background(), l.Client, []string{key}, token).Err()
+	}, true, nil
+}
@@ -0,0 +1,29 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/vine-io/vine/lib/cache"
+)
+
+// Transformer converts the record values on their way to and from redis, the
+// transformers of the cache/transform plugin implement it.
+type Transformer interface {
+	// Encode is applied to the value before it is written
+	Encode(b []byte) ([]byte, error)
+	// Decode reverses Encode on the value read
+	Decode(b []byte) ([]byte, error)
+}
+
+type transformerKey struct{}
+
+// WithTransformer sets the Transformer of the record values, e.g. to compress
+// or encrypt them
+func WithTransformer(t Transformer) cache.Option {
+	return func(o *cache.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, transformerKey{}, t)
+	}
+}
@@ -14,9 +14,24 @@ func init() {
 	cmd.DefaultCaches["redis"] = NewCache
 }
 
+var _ Cache = (*rkv)(nil)
+
+// Cache is the redis implementation of cache.Cache with its extensions
+type Cache interface {
+	cache.Cache
+	// MGet reads the records of the given keys in one pipeline
+	MGet(ctx context.Context, keys []string, opts ...cache.GetOption) ([]*Result, error)
+	// MPut writes the records in one pipeline
+	MPut(ctx context.Context, records []*cache.Record, opts ...cache.PutOption) ([]*Result, error)
+	// MDel removes the records of the given keys in one pipeline
+	MDel(ctx context.Context, keys []string, opts ...cache.DelOption) ([]*Result, error)
+}
+
 type rkv struct {
 	options cache.Options
 	Client  *redis.Client
+
+	transformer Transformer
 }
 
 func (r *rkv) Init(opts ...cache.Option) error {
@@ -73,6 +88,10 @@ func (r *rkv) Get(ctx context.Context, key string, opts ...cache.GetOption) ([]*
 			return nil, cache.ErrNotFound
 		}
 
+		if val, err = r.decode(val); err != nil {
+			return nil, err
+		}
+
 		d, err := r.Client.TTL(ctx, rkey).Result()
 		if err != nil {
 			return nil, err
@@ -108,8 +127,13 @@ func (r *rkv) Put(ctx context.Context, record *cache.Record, opts ...cache.PutOp
 		o(&options)
 	}
 
+	val, err := r.encode(record.Value)
+	if err != nil {
+		return err
+	}
+
 	rkey := fmt.Sprintf("%s%s", options.Table, record.Key)
-	return r.Client.Set(ctx, rkey, record.Value, record.Expiry).Err()
+	return r.Client.Set(ctx, rkey, val, record.Expiry).Err()
 }
 
 func (r *rkv) List(ctx context.Context, opts ...cache.ListOption) ([]string, error) {
@@ -173,5 +197,26 @@ func (r *rkv) configure() error {
 
 	r.Client = redis.NewClient(redisOptions)
 
+	if r.options.Context != nil {
+		t, ok := r.options.Context.Value(transformerKey{}).(Transformer)
+		if ok {
+			r.transformer = t
+		}
+	}
+
 	return nil
 }
+
+func (r *rkv) encode(v []byte) ([]byte, error) {
+	if r.transformer == nil {
+		return v, nil
+	}
+	return r.transformer.Encode(v)
+}
+
+func (r *rkv) decode(v []byte) ([]byte, error) {
+	if r.transformer == nil {
+		return v, nil
+	}
+	return r.transformer.Decode(v)
+}
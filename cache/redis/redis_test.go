@@ -123,3 +123,44 @@ func Test_Store(t *testing.T) {
 		t.Errorf("listing error %v\n", err)
 	}
 }
+
+func Test_Batch(t *testing.T) {
+	if tr := os.Getenv("TRAVIS"); len(tr) > 0 {
+		t.Skip()
+	}
+	r := new(rkv)
+	r.options = cache.Options{Nodes: []string{"redis://127.0.0.1:6379"}}
+
+	if err := r.configure(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx := context.Background()
+	records := []*cache.Record{
+		{Key: "batch1", Value: []byte("value1"), Expiry: time.Minute},
+		{Key: "batch2", Value: []byte("value2"), Expiry: time.Minute},
+	}
+	if _, err := r.MPut(ctx, records); err != nil {
+		t.Fatalf("batch write error %v", err)
+	}
+
+	results, err := r.MGet(ctx, []string{"batch1", "batch2", "batch3"})
+	if err != nil {
+		t.Fatalf("batch read error %v", err)
+	}
+	if results[0].Record == nil || results[1].Record == nil || results[2].Err != cache.ErrNotFound {
+		t.Fatalf("unexpected results %v", results)
+	}
+
+	// a missing key is deleted like with Del
+	results, err = r.MDel(ctx, []string{"batch1", "batch2", "missing"})
+	if err != nil {
+		t.Fatalf("batch delete error %v", err)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("unexpected delete error of %s: %v", result.Key, result.Err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// the first byte of a compressed value tells how it is compressed
+const (
+	codecNone byte = iota
+	codecGzip
+	codecZstd
+)
+
+var ErrInvalidCodec = errors.New("invalid compression codec")
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+type compressor struct {
+	codec     byte
+	threshold int
+}
+
+// Gzip compresses values larger than threshold bytes with gzip
+func Gzip(threshold int) Transformer {
+	return &compressor{codec: codecGzip, threshold: threshold}
+}
+
+// Zstd compresses values larger than threshold bytes with zstd
+func Zstd(threshold int) Transformer {
+	return &compressor{codec: codecZstd, threshold: threshold}
+}
+
+func (c *compressor) Encode(b []byte) ([]byte, error) {
+	if len(b) <= c.threshold {
+		return append([]byte{codecNone}, b...), nil
+	}
+
+	switch c.codec {
+	case codecGzip:
+		buf := bytes.NewBuffer([]byte{codecGzip})
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case codecZstd:
+		return zstdEncoder.EncodeAll(b, []byte{codecZstd}), nil
+	}
+
+	return nil, ErrInvalidCodec
+}
+
+// Decode decompresses values of any codec, so that the codec can be changed
+// without dropping the cached values.
+func (c *compressor) Decode(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, ErrInvalidCodec
+	}
+
+	switch b[0] {
+	case codecNone:
+		return b[1:], nil
+	case codecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b[1:]))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case codecZstd:
+		return zstdDecoder.DecodeAll(b[1:], nil)
+	}
+
+	return nil, ErrInvalidCodec
+}
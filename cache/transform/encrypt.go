@@ -0,0 +1,176 @@
+package transform
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	gosync "sync"
+)
+
+const (
+	envelopeVersion byte = 1
+	dataKeySize          = 32
+)
+
+var ErrInvalidEnvelope = errors.New("invalid encrypted value")
+
+// Keyring holds the key encryption keys by id. New values are encrypted with
+// the primary key, older keys are kept to decrypt the values written before a rotation.
+type Keyring struct {
+	mu      gosync.RWMutex
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring returns a Keyring with the primary key, the key must be 16, 24 or 32 bytes
+func NewKeyring(id string, key []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	if err := k.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Add adds a key which is used to decrypt values only
+func (k *Keyring) Add(id string, key []byte) error {
+	if len(id) == 0 || len(id) > 255 {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.keys[id] = aead
+	k.mu.Unlock()
+	return nil
+}
+
+// Rotate adds the key and makes it the primary key
+func (k *Keyring) Rotate(id string, key []byte) error {
+	if err := k.Add(id, key); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.primary = id
+	k.mu.Unlock()
+	return nil
+}
+
+// Remove drops a retired key, values encrypted with it can't be read anymore
+func (k *Keyring) Remove(id string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id != k.primary {
+		delete(k.keys, id)
+	}
+}
+
+func (k *Keyring) get(id string) (cipher.AEAD, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	aead, ok := k.keys[id]
+	return aead, ok
+}
+
+func (k *Keyring) current() (string, cipher.AEAD) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary, k.keys[k.primary]
+}
+
+type envelope struct {
+	keys *Keyring
+}
+
+// AESGCM encrypts values with a random data key using AES-GCM, the data key
+// is stored next to the value encrypted by the primary key of the keyring.
+//
+// The layout of the value is
+//
+//	version | len(key id) | key id | nonce | encrypted data key | nonce | encrypted value
+func AESGCM(keys *Keyring) Transformer {
+	return &envelope{keys: keys}
+}
+
+func (e *envelope) Encode(b []byte) ([]byte, error) {
+	id, kek := e.keys.current()
+
+	dek := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte{envelopeVersion, byte(len(id))}, id...)
+	out := append([]byte{}, header...)
+	out, err = seal(kek, out, dek, header)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, out, b, header)
+}
+
+func (e *envelope) Decode(b []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != envelopeVersion || len(b) < 2+int(b[1]) {
+		return nil, ErrInvalidEnvelope
+	}
+	n := 2 + int(b[1])
+	header, id := b[:n], string(b[2:n])
+
+	kek, ok := e.keys.get(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+
+	dek, rest, err := open(kek, b[n:], dataKeySize+kek.Overhead(), header)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	v, _, err := open(aead, rest, len(rest)-aead.NonceSize(), header)
+	return v, err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal appends a random nonce and the sealed plaintext to dst
+func seal(aead cipher.AEAD, dst, plaintext, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, ad), nil
+}
+
+// open reads a nonce and a sealed text of the given size from b
+func open(aead cipher.AEAD, b []byte, size int, ad []byte) ([]byte, []byte, error) {
+	ns := aead.NonceSize()
+	if size < aead.Overhead() || len(b) < ns+size {
+		return nil, nil, ErrInvalidEnvelope
+	}
+	plaintext, err := aead.Open(nil, b[:ns], b[ns:ns+size], ad)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, b[ns+size:], nil
+}
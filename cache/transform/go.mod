@@ -0,0 +1,5 @@
+module github.com/vine-io/plugins/cache/transform
+
+go 1.18
+
+require github.com/klauspost/compress v1.17.4
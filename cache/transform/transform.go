@@ -0,0 +1,40 @@
+// Package transform provides value transformers for the cache plugins, e.g.
+// compression and encryption of the cached values.
+package transform
+
+// Transformer converts values on their way to and from the cache
+type Transformer interface {
+	// Encode is applied to the value before it is written
+	Encode(b []byte) ([]byte, error)
+	// Decode reverses Encode on the value read
+	Decode(b []byte) ([]byte, error)
+}
+
+type chain []Transformer
+
+// Chain returns a Transformer which encodes with the given transformers in
+// order and decodes in reverse order, e.g. Chain(Gzip(1024), AESGCM(keys))
+// compresses before encryption.
+func Chain(ts ...Transformer) Transformer {
+	return chain(ts)
+}
+
+func (c chain) Encode(b []byte) ([]byte, error) {
+	var err error
+	for _, t := range c {
+		if b, err = t.Encode(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (c chain) Decode(b []byte) ([]byte, error) {
+	var err error
+	for i := len(c) - 1; i >= 0; i-- {
+		if b, err = c[i].Decode(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
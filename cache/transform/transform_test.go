@@ -0,0 +1,80 @@
+package transform
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	keys, err := NewKeyring("k1", bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := bytes.Repeat([]byte("value"), 1024)
+	tests := []struct {
+		name  string
+		t     Transformer
+		value []byte
+	}{
+		{name: "gzip", t: Gzip(16), value: large},
+		{name: "zstd", t: Zstd(16), value: large},
+		{name: "small", t: Zstd(16), value: []byte("small")},
+		{name: "aes", t: AESGCM(keys), value: []byte("secret")},
+		{name: "chain", t: Chain(Zstd(16), AESGCM(keys)), value: large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.t.Encode(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := tt.t.Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v, tt.value) {
+				t.Fatalf("decoded value mismatch")
+			}
+		})
+	}
+
+	b, _ := Gzip(16).Encode(large)
+	if len(b) >= len(large) {
+		t.Fatalf("value is not compressed")
+	}
+	if v, err := Zstd(16).Decode(b); err != nil || !bytes.Equal(v, large) {
+		t.Fatalf("decode value of another codec: %v", err)
+	}
+}
+
+func TestKeyring_Rotate(t *testing.T) {
+	keys, err := NewKeyring("k1", bytes.Repeat([]byte("1"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := AESGCM(keys)
+
+	old, err := tr.Encode([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = keys.Rotate("k2", bytes.Repeat([]byte("2"), 16)); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := tr.Decode(old); err != nil || string(v) != "secret" {
+		t.Fatalf("decode value of rotated key: %v", err)
+	}
+
+	keys.Remove("k1")
+	if _, err = tr.Decode(old); err == nil {
+		t.Fatal("expected error for removed key")
+	}
+
+	b, _ := tr.Encode([]byte("secret"))
+	b[len(b)-1] ^= 0xff
+	if _, err = tr.Decode(b); err == nil {
+		t.Fatal("expected error for tampered value")
+	}
+}
@@ -3,8 +3,13 @@ package configmap
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/vine-io/vine/lib/config/source"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -16,13 +21,28 @@ var (
 	DefaultNamespace  = "default"
 )
 
+// OrderAnnotation is the annotation of the precedence of a ConfigMap selected
+// by WithLabelSelector, the ConfigMaps of a higher order override the lower
+// ones. ConfigMaps of the same order are merged in order of their namespace
+// and name.
+const OrderAnnotation = "config.vine.io/order"
+
 type configmap struct {
 	opts       source.Options
-	client     *kubernetes.Clientset
+	codec      *codec
+	client     kubernetes.Interface
 	cerr       error
 	name       string
 	namespace  string
+	selector   string
 	configPath string
+
+	mu sync.Mutex
+	// resourceVersion of the ConfigMap when it was last read or written
+	resourceVersion string
+	// binary holds the BinaryData entries as they appear in the changeset
+	// of the last read or write
+	binary map[string]interface{}
 }
 
 func (k *configmap) Read() (*source.ChangeSet, error) {
@@ -30,12 +50,79 @@ func (k *configmap) Read() (*source.ChangeSet, error) {
 		return nil, k.cerr
 	}
 
-	cmp, err := k.client.CoreV1().ConfigMaps(k.namespace).Get(context.TODO(), k.name, v1.GetOptions{})
+	ctx := context.TODO()
+	client := k.client.CoreV1().ConfigMaps(k.namespace)
+
+	if k.selector != "" {
+		list, err := client.List(ctx, v1.ListOptions{LabelSelector: k.selector})
+		if err != nil {
+			return nil, err
+		}
+		cmps := make([]*corev1.ConfigMap, 0, len(list.Items))
+		for i := range list.Items {
+			cmps = append(cmps, &list.Items[i])
+		}
+		return k.changeSet(cmps)
+	}
+
+	cmp, err := client.Get(ctx, k.name, v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := k.changeSet([]*corev1.ConfigMap{cmp})
 	if err != nil {
 		return nil, err
 	}
+	k.remember(cmp, cs)
+
+	return cs, nil
+}
 
-	data := makeMap(cmp.Data)
+// remember records the ConfigMap last read or written, cs is its changeset
+// if it could be built
+func (k *configmap) remember(cmp *corev1.ConfigMap, cs *source.ChangeSet) {
+	var data map[string]interface{}
+	if cs != nil {
+		_ = k.opts.Encoder.Decode(cs.Data, &data)
+	}
+
+	binary := make(map[string]interface{})
+	for name := range cmp.BinaryData {
+		if _, ok := cmp.Data[name]; ok {
+			continue
+		}
+		if v, ok := data[name]; ok {
+			binary[name] = v
+		}
+	}
+
+	k.mu.Lock()
+	k.resourceVersion = cmp.ResourceVersion
+	k.binary = binary
+	k.mu.Unlock()
+}
+
+// changeSet merges the ConfigMaps in the order of OrderAnnotation
+func (k *configmap) changeSet(cmps []*corev1.ConfigMap) (*source.ChangeSet, error) {
+	sortConfigMaps(cmps)
+
+	var t time.Time
+	data := make(map[string]interface{})
+	for _, cmp := range cmps {
+		m, err := k.codec.decode(cmp)
+		if err != nil {
+			return nil, fmt.Errorf("error reading source: %v", err)
+		}
+		merge(data, m)
+
+		if lm := lastModified(cmp); lm.After(t) {
+			t = lm
+		}
+	}
+	if len(cmps) == 0 {
+		t = time.Now()
+	}
 
 	b, err := k.opts.Encoder.Encode(data)
 	if err != nil {
@@ -46,16 +133,27 @@ func (k *configmap) Read() (*source.ChangeSet, error) {
 		Format:    k.opts.Encoder.String(),
 		Source:    k.String(),
 		Data:      b,
-		Timestamp: cmp.CreationTimestamp.Time,
+		Timestamp: t,
 	}
 	cs.CheckSum = cs.Sum()
 
 	return cs, nil
 }
 
-// Write is unsupported
-func (k *configmap) Write(cs *source.ChangeSet) error {
-	return nil
+func sortConfigMaps(cmps []*corev1.ConfigMap) {
+	order := func(cmp *corev1.ConfigMap) int {
+		n, _ := strconv.Atoi(cmp.Annotations[OrderAnnotation])
+		return n
+	}
+	sort.SliceStable(cmps, func(i, j int) bool {
+		if oi, oj := order(cmps[i]), order(cmps[j]); oi != oj {
+			return oi < oj
+		}
+		if cmps[i].Namespace != cmps[j].Namespace {
+			return cmps[i].Namespace < cmps[j].Namespace
+		}
+		return cmps[i].Name < cmps[j].Name
+	})
 }
 
 func (k *configmap) String() string {
@@ -67,7 +165,7 @@ func (k *configmap) Watch() (source.Watcher, error) {
 		return nil, k.cerr
 	}
 
-	w, err := newWatcher(k.name, k.namespace, k.client, k.opts)
+	w, err := newWatcher(k)
 	if err != nil {
 		return nil, err
 	}
@@ -103,15 +201,28 @@ func NewSource(opts ...source.Option) source.Source {
 		namespace = ns
 	}
 
-	// TODO handle if the client fails what to do current return does not support error
-	client, err := getClient(configPath)
+	selector, _ := options.Context.Value(labelSelectorKey{}).(string)
+	if all, ok := options.Context.Value(allNamespacesKey{}).(bool); ok && all && selector != "" {
+		namespace = v1.NamespaceAll
+	}
+
+	var client kubernetes.Interface
+	var err error
+	if c, ok := options.Context.Value(clientKey{}).(kubernetes.Interface); ok {
+		client = c
+	} else {
+		// TODO handle if the client fails what to do current return does not support error
+		client, err = getClient(configPath)
+	}
 
 	return &configmap{
 		cerr:       err,
 		client:     client,
 		opts:       options,
+		codec:      newCodec(options),
 		name:       name,
 		configPath: configPath,
 		namespace:  namespace,
+		selector:   selector,
 	}
 }
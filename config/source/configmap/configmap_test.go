@@ -0,0 +1,363 @@
+package configmap
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vine-io/vine/lib/config/source"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigmap_Write(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "vine", Namespace: "default", ResourceVersion: "1"},
+		Data:       map[string]string{"config": "host=0.0.0.0\nport=1337"},
+	})
+	s := NewSource(WithClient(client))
+
+	if _, err := s.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := &source.ChangeSet{Data: []byte(`{"config": {"host": "127.0.0.1", "port": 8080, "debug": true}, "redis": {"url": "redis://127.0.0.1:6379"}}`)}
+	if err := s.Write(cs); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.TODO()
+	cmp, err := client.CoreV1().ConfigMaps("default").Get(ctx, "vine", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := cmp.Data["config"]; v != "debug=true\nhost=127.0.0.1\nport=8080" {
+		t.Fatalf("unexpected config %q", v)
+	}
+	if v := cmp.Data["redis"]; v != "url=redis://127.0.0.1:6379" {
+		t.Fatalf("unexpected redis %q", v)
+	}
+
+	// a concurrent update is not clobbered
+	cmp.ResourceVersion = "2"
+	if _, err = client.CoreV1().ConfigMaps("default").Update(ctx, cmp, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Write(cs); err != ErrConflict {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+
+	if _, err = s.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Write(cs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigmap_WriteCreate(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := NewSource(WithClient(client), WithNamespace("kube-public"), WithName("vine-config"))
+
+	cs := &source.ChangeSet{Data: []byte(`{"mongodb": {"host": "127.0.0.1", "port": 27017}}`)}
+	if err := s.Write(cs); err != nil {
+		t.Fatal(err)
+	}
+
+	rcs, err := s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err = s.(*configmap).opts.Encoder.Decode(rcs.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"mongodb": map[string]interface{}{"host": "127.0.0.1", "port": "27017"}}
+	if !reflect.DeepEqual(data, expected) {
+		t.Fatalf("unexpected config %s", rcs.Data)
+	}
+
+	if err = s.Write(&source.ChangeSet{Data: []byte(`{"mongodb": {"hosts": ["a", "b"]}}`)}); err == nil {
+		t.Fatal("expected error for a list value")
+	}
+}
+
+func TestConfigmap_Formats(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "vine", Namespace: "default"},
+		Data: map[string]string{
+			"app.yaml":  "server:\n  host: 0.0.0.0\n  port: 8080\n",
+			"db.json":   `{"database": {"host": "127.0.0.1"}, "server": {"port": 9090}}`,
+			"settings":  "mode=debug",
+			"overrides": "level=info",
+		},
+		BinaryData: map[string][]byte{
+			"cert":  []byte{0x01, 0x02},
+			"extra": []byte("cache = \"redis\""),
+		},
+	})
+	s := NewSource(WithClient(client), WithKeyFormat("extra", "toml"))
+
+	rcs, err := s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data map[string]interface{}
+	if err = s.(*configmap).opts.Encoder.Decode(rcs.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"server":    map[string]interface{}{"host": "0.0.0.0", "port": float64(9090)},
+		"database":  map[string]interface{}{"host": "127.0.0.1"},
+		"settings":  map[string]interface{}{"mode": "debug"},
+		"overrides": map[string]interface{}{"level": "info"},
+		"cache":     "redis",
+		"cert":      "AQI=",
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Fatalf("unexpected config %s", rcs.Data)
+	}
+
+	// the entries stay in their keys, new ones are added to the last document
+	data["database"].(map[string]interface{})["port"] = 3306
+	data["broker"] = map[string]interface{}{"address": "nats"}
+	delete(data, "overrides")
+	b, err := s.(*configmap).opts.Encoder.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Write(&source.ChangeSet{Data: b}); err != nil {
+		t.Fatal(err)
+	}
+
+	cmp, err := client.CoreV1().ConfigMaps("default").Get(context.TODO(), "vine", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cmp.Data["overrides"]; ok {
+		t.Fatal("expected overrides to be removed")
+	}
+	if cmp.Data["settings"] != "mode=debug" {
+		t.Fatalf("unexpected settings %q", cmp.Data["settings"])
+	}
+	if string(cmp.BinaryData["cert"]) != "\x01\x02" {
+		t.Fatalf("unexpected cert %v", cmp.BinaryData["cert"])
+	}
+	if !strings.Contains(cmp.Data["app.yaml"], "port: 9090") {
+		t.Fatalf("unexpected app.yaml %s", cmp.Data["app.yaml"])
+	}
+	if !strings.Contains(cmp.Data["db.json"], `"port":3306`) {
+		t.Fatalf("unexpected db.json %s", cmp.Data["db.json"])
+	}
+	if !strings.Contains(string(cmp.BinaryData["extra"]), "address = \"nats\"") {
+		t.Fatalf("unexpected extra %s", cmp.BinaryData["extra"])
+	}
+
+	rcs, err = s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rdata map[string]interface{}
+	if err = s.(*configmap).opts.Encoder.Decode(rcs.Data, &rdata); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.(*configmap).opts.Encoder.Decode(b, &data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rdata, data) {
+		t.Fatalf("unexpected config %s", rcs.Data)
+	}
+}
+
+func TestConfigmap_WriteBinary(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "vine", Namespace: "default"},
+		BinaryData: map[string][]byte{
+			"cert":  []byte{0x01, 0x02},
+			"token": []byte("old"),
+		},
+	})
+	s := NewSource(WithClient(client))
+
+	rcs, err := s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err = s.(*configmap).opts.Encoder.Decode(rcs.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new value which happens to be valid base64 is stored as it is
+	data["token"] = "abcd"
+	b, err := s.(*configmap).opts.Encoder.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Write(&source.ChangeSet{Data: b}); err != nil {
+		t.Fatal(err)
+	}
+
+	cmp, err := client.CoreV1().ConfigMaps("default").Get(context.TODO(), "vine", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cmp.BinaryData["cert"]) != "\x01\x02" {
+		t.Fatalf("unexpected cert %v", cmp.BinaryData["cert"])
+	}
+	if string(cmp.BinaryData["token"]) != "abcd" {
+		t.Fatalf("unexpected token %q", cmp.BinaryData["token"])
+	}
+}
+
+func TestConfigmap_Watch(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := NewSource(WithClient(client))
+
+	w, err := s.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	next := func(expected map[string]interface{}) {
+		cs, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data map[string]interface{}
+		if err = s.(*configmap).opts.Encoder.Decode(cs.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(data, expected) {
+			t.Fatalf("unexpected config %s", cs.Data)
+		}
+	}
+
+	ctx := context.TODO()
+	cmps := client.CoreV1().ConfigMaps("default")
+	now := v1.Now()
+	cmp := &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "vine", Namespace: "default", ResourceVersion: "1"},
+		Data:       map[string]string{"config": "port=1337"},
+	}
+	if _, err = cmps.Create(ctx, cmp, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	next(map[string]interface{}{"config": map[string]interface{}{"port": "1337"}})
+
+	// other ConfigMaps are ignored
+	other := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "other", Namespace: "default"}}
+	if _, err = cmps.Create(ctx, other, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cmp.ResourceVersion = "2"
+	cmp.Data["config"] = "port=8080"
+	cmp.ManagedFields = []v1.ManagedFieldsEntry{{Manager: "test", Time: &now}}
+	if _, err = cmps.Update(ctx, cmp, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cs.Timestamp.Equal(now.Time) {
+		t.Fatalf("unexpected timestamp %v", cs.Timestamp)
+	}
+
+	// a document which fails to decode is returned as error
+	cmp.ResourceVersion = "3"
+	cmp.Data["app.json"] = "{"
+	if _, err = cmps.Update(ctx, cmp, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Next(); err == nil {
+		t.Fatal("expected decode error")
+	}
+
+	if err = cmps.Delete(ctx, "vine", v1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	next(map[string]interface{}{})
+
+	if err = w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Next(); err == nil {
+		t.Fatal("expected stopped watcher")
+	}
+}
+
+func TestConfigmap_LabelSelector(t *testing.T) {
+	newConfigMap := func(ns, name, order, config string) *corev1.ConfigMap {
+		cmp := &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: ns, Labels: map[string]string{"app": "vine"}},
+			Data:       map[string]string{"config.json": config},
+		}
+		if order != "" {
+			cmp.Annotations = map[string]string{OrderAnnotation: order}
+		}
+		return cmp
+	}
+
+	client := fake.NewSimpleClientset(
+		newConfigMap("default", "base", "", `{"server": {"host": "0.0.0.0", "port": 8080}}`),
+		newConfigMap("prod", "override", "10", `{"server": {"port": 80}}`),
+		newConfigMap("default", "extra", "1", `{"server": {"port": 9090}, "debug": true}`),
+	)
+	s := NewSource(WithClient(client), WithLabelSelector("app=vine"), WithAllNamespaces())
+
+	decode := func(cs *source.ChangeSet) map[string]interface{} {
+		var data map[string]interface{}
+		if err := s.(*configmap).opts.Encoder.Decode(cs.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	cs, err := s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"server": map[string]interface{}{"host": "0.0.0.0", "port": float64(80)},
+		"debug":  true,
+	}
+	if data := decode(cs); !reflect.DeepEqual(data, expected) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+
+	if err = s.Write(cs); err == nil {
+		t.Fatal("expected write to be unsupported")
+	}
+
+	w, err := s.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// the initial ConfigMaps are sent in one changeset
+	if cs, err = w.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if data := decode(cs); !reflect.DeepEqual(data, expected) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+
+	top := newConfigMap("staging", "top", "20", `{"server": {"host": "127.0.0.1"}}`)
+	if _, err = client.CoreV1().ConfigMaps("staging").Create(context.TODO(), top, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if cs, err = w.Next(); err != nil {
+		t.Fatal(err)
+	}
+	expected["server"] = map[string]interface{}{"host": "127.0.0.1", "port": float64(80)}
+	if data := decode(cs); !reflect.DeepEqual(data, expected) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+}
@@ -0,0 +1,249 @@
+package configmap
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/vine-io/vine/lib/config/encoder"
+	"github.com/vine-io/vine/lib/config/encoder/hcl"
+	"github.com/vine-io/vine/lib/config/encoder/json"
+	"github.com/vine-io/vine/lib/config/encoder/toml"
+	"github.com/vine-io/vine/lib/config/encoder/yaml"
+	"github.com/vine-io/vine/lib/config/source"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// codec converts the keys of a ConfigMap to the config tree and back. A key
+// holding a document in a known format is merged into the tree, any other
+// key is an entry of the tree: newline separated k=v lines for Data and the
+// raw bytes for BinaryData.
+type codec struct {
+	formats  map[string]string
+	encoders map[string]encoder.Encoder
+}
+
+func newCodec(opts source.Options) *codec {
+	c := &codec{
+		formats: make(map[string]string),
+		encoders: map[string]encoder.Encoder{
+			"json": json.NewEncoder(),
+			"yaml": yaml.NewEncoder(),
+			"yml":  yaml.NewEncoder(),
+			"toml": toml.NewEncoder(),
+			"hcl":  hcl.NewEncoder(),
+		},
+	}
+
+	if f, ok := opts.Context.Value(formatsKey{}).(map[string]string); ok {
+		for k, v := range f {
+			c.formats[k] = v
+		}
+	}
+	if e, ok := opts.Context.Value(encodersKey{}).([]encoder.Encoder); ok {
+		for _, v := range e {
+			c.encoders[v.String()] = v
+		}
+	}
+
+	return c
+}
+
+// encoder returns the encoder of the document in the key, which is given by
+// WithKeyFormat or the extension of the key
+func (c *codec) encoder(key string) (encoder.Encoder, bool) {
+	f, ok := c.formats[key]
+	if !ok {
+		i := strings.LastIndex(key, ".")
+		if i == -1 {
+			return nil, false
+		}
+		f = key[i+1:]
+	}
+	e, ok := c.encoders[f]
+	return e, ok
+}
+
+// keys returns the keys of the ConfigMap sorted, the later ones take
+// precedence when merged
+func keys(cmp *corev1.ConfigMap) []string {
+	var keys []string
+	for k := range cmp.Data {
+		keys = append(keys, k)
+	}
+	for k := range cmp.BinaryData {
+		if _, ok := cmp.Data[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decode merges the keys of the ConfigMap into one tree
+func (c *codec) decode(cmp *corev1.ConfigMap) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+
+	for _, k := range keys(cmp) {
+		v, text := cmp.Data[k]
+		b := []byte(v)
+		if !text {
+			b = cmp.BinaryData[k]
+		}
+
+		if e, ok := c.encoder(k); ok {
+			var doc map[string]interface{}
+			if err := e.Decode(b, &doc); err != nil {
+				return nil, fmt.Errorf("error decoding %s: %v", k, err)
+			}
+			merge(data, doc)
+			continue
+		}
+
+		if text {
+			merge(data, makeMap(map[string]string{k: v}))
+		} else {
+			data[k] = b
+		}
+	}
+
+	return data, nil
+}
+
+// encode splits the tree into the keys of the ConfigMap. An entry is stored
+// by the key which held it in cmp, the new entries are added to the last
+// document or to a key of their own when cmp holds no document. read holds the
+// BinaryData entries as they were read, an unchanged one keeps its bytes.
+func (c *codec) encode(data map[string]interface{}, cmp *corev1.ConfigMap, read map[string]interface{}) (map[string]string, map[string][]byte, error) {
+	type doc struct {
+		e       encoder.Encoder
+		binary  bool
+		entries map[string]interface{}
+	}
+
+	docs := make(map[string]*doc)
+	binary := make(map[string]bool)
+	owners := make(map[string]string)
+
+	var last string
+	names := keys(cmp)
+	for k := range c.formats {
+		if _, ok := cmp.Data[k]; !ok {
+			if _, ok = cmp.BinaryData[k]; !ok {
+				names = append(names, k)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		v, text := cmp.Data[k]
+		b := []byte(v)
+		if !text {
+			b = cmp.BinaryData[k]
+		}
+
+		e, ok := c.encoder(k)
+		if !ok {
+			if _, ok = owners[k]; !ok {
+				owners[k] = k
+			}
+			binary[k] = !text && b != nil
+			continue
+		}
+
+		docs[k] = &doc{e: e, binary: !text && b != nil, entries: make(map[string]interface{})}
+		last = k
+		var entries map[string]interface{}
+		if len(b) > 0 && e.Decode(b, &entries) == nil {
+			for name := range entries {
+				// an entry merged from several keys is kept by the first
+				if _, ok = owners[name]; !ok {
+					owners[name] = k
+				}
+			}
+		}
+	}
+
+	kv := make(map[string]interface{})
+	bin := make(map[string][]byte)
+	for name, v := range data {
+		owner, ok := owners[name]
+		if !ok && last != "" {
+			owner, ok = last, true
+		}
+
+		switch {
+		case ok && docs[owner] != nil:
+			docs[owner].entries[name] = v
+		case ok && binary[owner]:
+			if r, ok := read[name]; ok && reflect.DeepEqual(r, v) {
+				bin[name] = cmp.BinaryData[name]
+				continue
+			}
+			b, err := toBytes(v)
+			if err != nil {
+				return nil, nil, fmt.Errorf("value of %s: %v", name, err)
+			}
+			bin[name] = b
+		default:
+			kv[name] = v
+		}
+	}
+
+	text, err := makeData(kv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, d := range docs {
+		if len(d.entries) == 0 {
+			continue
+		}
+		b, err := d.e.Encode(d.entries)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error encoding %s: %v", k, err)
+		}
+		if d.binary {
+			bin[k] = b
+		} else {
+			text[k] = string(b)
+		}
+	}
+
+	if len(bin) == 0 {
+		bin = nil
+	}
+	return text, bin, nil
+}
+
+// toBytes returns the bytes of a changed BinaryData entry, a string is stored
+// as it is
+func toBytes(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case []byte:
+		return t, nil
+	case string:
+		return []byte(t), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+// merge merges src into dst, the maps of both are merged recursively
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sm, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dm, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dm = make(map[string]interface{})
+			dst[k] = dm
+		}
+		merge(dm, sm)
+	}
+}
@@ -3,13 +3,20 @@ package configmap
 import (
 	"context"
 
+	"github.com/vine-io/vine/lib/config/encoder"
 	"github.com/vine-io/vine/lib/config/source"
+	"k8s.io/client-go/kubernetes"
 )
 
 type configPathKey struct{}
 type prefixKey struct{}
 type nameKey struct{}
 type namespaceKey struct{}
+type clientKey struct{}
+type labelSelectorKey struct{}
+type allNamespacesKey struct{}
+type formatsKey struct{}
+type encodersKey struct{}
 
 // WithNamespace is an option to add namespace of configmap
 func WithNamespace(s string) source.Option {
@@ -31,6 +38,29 @@ func WithName(s string) source.Option {
 	}
 }
 
+// WithLabelSelector is an option to merge all configmaps matching the
+// selector, e.g. "app=vine", instead of reading the configmap of WithName.
+// The precedence of the configmaps is set by OrderAnnotation.
+func WithLabelSelector(s string) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, labelSelectorKey{}, s)
+	}
+}
+
+// WithAllNamespaces is an option to select the configmaps of WithLabelSelector
+// in all namespaces
+func WithAllNamespaces() source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, allNamespacesKey{}, true)
+	}
+}
+
 // WithConfigPath option for setting a custom path to kubeconfig
 func WithConfigPath(s string) source.Option {
 	return func(o *source.Options) {
@@ -39,4 +69,47 @@ func WithConfigPath(s string) source.Option {
 		}
 		o.Context = context.WithValue(o.Context, configPathKey{}, s)
 	}
-}
\ No newline at end of file
+}
+
+// WithClient option for using an existing kubernetes client instead of the
+// in cluster or kubeconfig one
+func WithClient(c kubernetes.Interface) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, clientKey{}, c)
+	}
+}
+
+// WithKeyFormat is an option to set the format of the document in a key of
+// the configmap, e.g. "yaml". By default it is the extension of the key.
+func WithKeyFormat(key, format string) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		formats := map[string]string{key: format}
+		if f, ok := o.Context.Value(formatsKey{}).(map[string]string); ok {
+			for k, v := range f {
+				if k != key {
+					formats[k] = v
+				}
+			}
+		}
+		o.Context = context.WithValue(o.Context, formatsKey{}, formats)
+	}
+}
+
+// WithEncoder is an option to add the encoder of a document format, json,
+// yaml, toml and hcl are supported by default
+func WithEncoder(e encoder.Encoder) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		encoders, _ := o.Context.Value(encodersKey{}).([]encoder.Encoder)
+		encoders = append(append([]encoder.Encoder{}, encoders...), e)
+		o.Context = context.WithValue(o.Context, encodersKey{}, encoders)
+	}
+}
@@ -1,8 +1,13 @@
 package configmap
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -46,6 +51,62 @@ func makeMap(kv map[string]string) map[string]interface{} {
 	return data
 }
 
+// makeData reverses makeMap, every entry of the tree becomes a key of
+// newline separated k=v lines
+func makeData(data map[string]interface{}) (map[string]string, error) {
+	kv := make(map[string]string, len(data))
+
+	for k, v := range data {
+		mp, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value of %s is not a map", k)
+		}
+
+		keys := make([]string, 0, len(mp))
+		for m := range mp {
+			if strings.ContainsAny(m, "=\n") {
+				return nil, fmt.Errorf("invalid key %s.%s", k, m)
+			}
+			keys = append(keys, m)
+		}
+		sort.Strings(keys)
+
+		vals := make([]string, 0, len(keys))
+		for _, m := range keys {
+			n, err := format(mp[m])
+			if err != nil {
+				return nil, fmt.Errorf("value of %s.%s: %v", k, m, err)
+			}
+			vals = append(vals, m+"="+n)
+		}
+
+		kv[k] = strings.Join(vals, "\n")
+	}
+
+	return kv, nil
+}
+
+func format(v interface{}) (string, error) {
+	var s string
+	switch t := v.(type) {
+	case nil:
+	case string:
+		s = t
+	case bool:
+		s = strconv.FormatBool(t)
+	case float64:
+		s = strconv.FormatFloat(t, 'f', -1, 64)
+	case int, int64, uint64:
+		s = fmt.Sprint(t)
+	default:
+		return "", fmt.Errorf("unsupported type %T", v)
+	}
+	if strings.Contains(s, "\n") {
+		return "", fmt.Errorf("value contains a newline")
+	}
+	return s, nil
+}
+
 func split(s string, sp string) (k string, v string) {
 	i := strings.Index(s, sp)
 	if i == -1 {
@@ -53,3 +114,15 @@ func split(s string, sp string) (k string, v string) {
 	}
 	return s[:i], s[i+1:]
 }
+
+// lastModified returns the time of the last change of the ConfigMap recorded
+// by its managed fields, or its creation time
+func lastModified(cmp *v1.ConfigMap) time.Time {
+	t := cmp.CreationTimestamp.Time
+	for _, f := range cmp.ManagedFields {
+		if f.Time != nil && f.Time.After(t) {
+			t = f.Time.Time
+		}
+	}
+	return t
+}
@@ -1,81 +1,181 @@
 package configmap
 
 import (
+	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/vine-io/vine/lib/config/source"
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/client-go/kubernetes"
-	//"k8s.io/client-go/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
 )
 
 type watcher struct {
-	opts      source.Options
-	name      string
-	namespace string
-	client    *kubernetes.Clientset
-	st        cache.Store
-	ct        cache.Controller
-	ch        chan *source.ChangeSet
+	k        *configmap
+	selector labels.Selector
+	st       cache.Store
+	ct       cache.Controller
+	ch       chan *source.ChangeSet
+	errs     chan error
+
+	// mu orders the changesets, none is sent before the store is synced
+	mu     sync.Mutex
+	synced bool
 
 	exit chan bool
 	stop chan struct{}
 }
 
-func newWatcher(n, ns string, c *kubernetes.Clientset, opts source.Options) (source.Watcher, error) {
+func newWatcher(k *configmap) (source.Watcher, error) {
 	w := &watcher{
-		opts:      opts,
-		name:      n,
-		namespace: ns,
-		client:    c,
-		ch:        make(chan *source.ChangeSet),
-		exit:      make(chan bool),
-		stop:      make(chan struct{}),
+		k:    k,
+		ch:   make(chan *source.ChangeSet),
+		errs: make(chan error),
+		exit: make(chan bool),
+		stop: make(chan struct{}),
 	}
 
+	list := func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", k.name).String()
+	}
+	if k.selector != "" {
+		selector, err := labels.Parse(k.selector)
+		if err != nil {
+			return nil, err
+		}
+		w.selector = selector
+		list = func(options *metav1.ListOptions) {
+			options.LabelSelector = k.selector
+		}
+	}
 
-	lw := cache.NewListWatchFromClient(w.client.CoreV1().RESTClient(), "configmaps", w.namespace, fields.OneTermEqualSelector("metadata.name", w.name))
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list(&options)
+			return k.client.CoreV1().ConfigMaps(k.namespace).List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			list(&options)
+			return k.client.CoreV1().ConfigMaps(k.namespace).Watch(context.TODO(), options)
+		},
+	}
 	st, ct := cache.NewInformer(
 		lw,
 		&v1.ConfigMap{},
 		time.Second*30,
 		cache.ResourceEventHandlerFuncs{
-			UpdateFunc: w.handle,
+			AddFunc:    w.handleAdd,
+			UpdateFunc: w.handleUpdate,
+			DeleteFunc: w.handleDelete,
 		},
 	)
 
-	go ct.Run(w.stop)
-
 	w.ct = ct
 	w.st = st
 
+	go ct.Run(w.stop)
+	go w.sync()
+
 	return w, nil
 }
 
-func (w *watcher) handle(oldCmp interface{}, newCmp interface{}) {
-	if newCmp == nil {
+// match reports whether the ConfigMap is one of the source
+func (w *watcher) match(obj interface{}) bool {
+	cmp, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		return false
+	}
+	if w.selector != nil {
+		return w.selector.Matches(labels.Set(cmp.Labels))
+	}
+	return cmp.Name == w.k.name
+}
+
+func (w *watcher) handleAdd(obj interface{}) {
+	if w.match(obj) {
+		w.handle()
+	}
+}
+
+func (w *watcher) handleUpdate(oldObj, newObj interface{}) {
+	if !w.match(oldObj) && !w.match(newObj) {
 		return
 	}
+	// resyncs deliver the unchanged ConfigMap again
+	if o, n := oldObj.(*v1.ConfigMap), newObj.(*v1.ConfigMap); o.ResourceVersion == n.ResourceVersion {
+		return
+	}
+	w.handle()
+}
 
-	data := makeMap(newCmp.(*v1.ConfigMap).Data)
+func (w *watcher) handleDelete(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	if w.match(obj) {
+		w.handle()
+	}
+}
 
-	b, err := w.opts.Encoder.Encode(data)
-	if err != nil {
+// sync waits for the initial list of the ConfigMaps and sends them in one
+// changeset
+func (w *watcher) sync() {
+	if !cache.WaitForCacheSync(w.stop, w.ct.HasSynced) {
 		return
 	}
 
-	cs := &source.ChangeSet{
-		Format:    w.opts.Encoder.String(),
-		Source:    w.name,
-		Data:      b,
-		Timestamp: newCmp.(*v1.ConfigMap).CreationTimestamp.Time,
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.synced = true
+	if cmps := w.list(); len(cmps) > 0 {
+		w.send(cmps)
+	}
+}
+
+// list returns the ConfigMaps of the source in the store
+func (w *watcher) list() []*v1.ConfigMap {
+	var cmps []*v1.ConfigMap
+	for _, obj := range w.st.List() {
+		if w.match(obj) {
+			cmps = append(cmps, obj.(*v1.ConfigMap))
+		}
 	}
-	cs.CheckSum = cs.Sum()
+	return cmps
+}
 
-	w.ch <- cs
+// handle sends the changeset of the ConfigMaps in the store, it is empty
+// when the ConfigMap was deleted. The changes of the initial list are sent
+// by sync.
+func (w *watcher) handle() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.synced {
+		w.send(w.list())
+	}
+}
+
+// send sends the changeset of the ConfigMaps, a ConfigMap which fails to
+// decode is returned by Next as error
+func (w *watcher) send(cmps []*v1.ConfigMap) {
+	cs, err := w.k.changeSet(cmps)
+	if err != nil {
+		select {
+		case w.errs <- err:
+		case <-w.exit:
+		}
+		return
+	}
+
+	select {
+	case w.ch <- cs:
+	case <-w.exit:
+	}
 }
 
 // Next
@@ -83,6 +183,8 @@ func (w *watcher) Next() (*source.ChangeSet, error) {
 	select {
 	case cs := <-w.ch:
 		return cs, nil
+	case err := <-w.errs:
+		return nil, err
 	case <-w.exit:
 		return nil, errors.New("watcher stopped")
 	}
@@ -93,10 +195,9 @@ func (w *watcher) Stop() error {
 	select {
 	case <-w.exit:
 		return nil
-	case <-w.stop:
-		return nil
 	default:
 		close(w.exit)
+		close(w.stop)
 	}
 	return nil
 }
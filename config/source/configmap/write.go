@@ -0,0 +1,79 @@
+package configmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vine-io/vine/lib/config/source"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrConflict is returned by Write when the ConfigMap was changed since it
+// was last read or written by the source
+var ErrConflict = errors.New("configmap changed since last read")
+
+// Write stores the changeset in the ConfigMap, it is created when missing.
+// The update is rejected with ErrConflict when the resourceVersion of the
+// ConfigMap is not the one of the last Read or Write.
+func (k *configmap) Write(cs *source.ChangeSet) error {
+	if k.cerr != nil {
+		return k.cerr
+	}
+	if k.selector != "" {
+		return errors.New("write of configmaps selected by label is unsupported")
+	}
+
+	var data map[string]interface{}
+	if err := k.opts.Encoder.Decode(cs.Data, &data); err != nil {
+		return fmt.Errorf("error decoding changeset: %v", err)
+	}
+
+	ctx := context.TODO()
+	client := k.client.CoreV1().ConfigMaps(k.namespace)
+
+	k.mu.Lock()
+	rv, binary := k.resourceVersion, k.binary
+	k.mu.Unlock()
+
+	cmp, err := client.Get(ctx, k.name, v1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		cmp = &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: k.name, Namespace: k.namespace},
+		}
+		if cmp.Data, cmp.BinaryData, err = k.codec.encode(data, cmp, binary); err != nil {
+			return fmt.Errorf("error writing source: %v", err)
+		}
+		cmp, err = client.Create(ctx, cmp, v1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return ErrConflict
+		}
+	case err != nil:
+		return err
+	default:
+		if rv != "" && rv != cmp.ResourceVersion {
+			return ErrConflict
+		}
+
+		// the server rejects the update if the ConfigMap changed since the Get
+		cmp = cmp.DeepCopy()
+		if cmp.Data, cmp.BinaryData, err = k.codec.encode(data, cmp, binary); err != nil {
+			return fmt.Errorf("error writing source: %v", err)
+		}
+		cmp, err = client.Update(ctx, cmp, v1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			return ErrConflict
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	written, _ := k.changeSet([]*corev1.ConfigMap{cmp})
+	k.remember(cmp, written)
+
+	return nil
+}
@@ -2,8 +2,13 @@ package etcd
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/vine-io/vine/lib/config/source"
@@ -22,6 +27,24 @@ type etcd struct {
 	opts        source.Options
 	client      *clientv3.Client
 	cerr        error
+	// guard rejects writes when the config changed since the last read
+	guard bool
+
+	mu  sync.Mutex
+	sum string
+	// rev is the revision of the last read, the watcher starts from its keys
+	// and changeset
+	rev int64
+	kvs []*mvccpb.KeyValue
+	cs  *source.ChangeSet
+}
+
+// Revisioner is implemented by the etcd source and its watcher, it returns
+// the etcd revision of the last changeset returned by Read or Next.
+//
+//	rev := etcdSource.(etcd.Revisioner).LastRevision()
+type Revisioner interface {
+	LastRevision() int64
 }
 
 func (c *etcd) Read() (*source.ChangeSet, error) {
@@ -29,21 +52,42 @@ func (c *etcd) Read() (*source.ChangeSet, error) {
 		return nil, c.cerr
 	}
 
-	rsp, err := c.client.Get(context.Background(), c.prefix)
+	_, cs, err := c.read()
+	return cs, err
+}
+
+// read returns the keys under the prefix and their changeset
+func (c *etcd) read() (*clientv3.GetResponse, *source.ChangeSet, error) {
+	rsp, err := c.client.Get(context.Background(), c.prefix, clientv3.WithPrefix())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if rsp == nil || len(rsp.Kvs) == 0 {
-		return nil, fmt.Errorf("source not found: %s", c.prefix)
+		return nil, nil, fmt.Errorf("source not found: %s", c.prefix)
 	}
 
-	kvs := make([]*mvccpb.KeyValue, 0, len(rsp.Kvs))
-	for _, v := range rsp.Kvs {
-		kvs = append(kvs, v)
+	cs, err := c.changeSet(rsp.Kvs)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	data := makeMap(c.opts.Encoder, kvs, c.stripPrefix)
+	c.mu.Lock()
+	c.sum = checksum(rsp.Kvs)
+	c.rev = rsp.Header.Revision
+	c.kvs = rsp.Kvs
+	c.cs = cs
+	c.mu.Unlock()
+
+	return rsp, cs, nil
+}
+
+// changeSet merges the keys into a changeset
+func (c *etcd) changeSet(kvs []*mvccpb.KeyValue) (*source.ChangeSet, error) {
+	data, err := makeMap(c.opts.Encoder, kvs, c.stripPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error reading source: %v", err)
+	}
 
 	b, err := c.opts.Encoder.Encode(data)
 	if err != nil {
@@ -61,6 +105,22 @@ func (c *etcd) Read() (*source.ChangeSet, error) {
 	return cs, nil
 }
 
+// checksum returns the checksum of the keys and their values, the encoded
+// config is not used as the encoder may not sort the map keys
+func checksum(kvs []*mvccpb.KeyValue) string {
+	h := md5.New()
+	for _, kv := range kvs {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(kv.Key), kv.Key, len(kv.Value), kv.Value)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func (c *etcd) LastRevision() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rev
+}
+
 func (c *etcd) String() string {
 	return "etcd"
 }
@@ -69,19 +129,26 @@ func (c *etcd) Watch() (source.Watcher, error) {
 	if c.cerr != nil {
 		return nil, c.cerr
 	}
-	cs, err := c.Read()
-	if err != nil {
-		return nil, err
-	}
-	return newWatcher(c.prefix, c.stripPrefix, c.client.Watcher, cs, c.opts)
-}
 
-func (c *etcd) Write(cs *source.ChangeSet) error {
-	return nil
+	// the changes since the last read are sent by the watcher
+	c.mu.Lock()
+	rev, kvs, cs := c.rev, c.kvs, c.cs
+	c.mu.Unlock()
+	if rev == 0 {
+		rsp, rcs, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		rev, kvs, cs = rsp.Header.Revision, rsp.Kvs, rcs
+	}
+	return newWatcher(c, cs, kvs, rev)
 }
 
-func NewSource(opts ...source.Option) source.Source {
-	options := source.NewOptions(opts...)
+// newClient returns the client of WithClient, or a new client of the options
+func newClient(options source.Options) (*clientv3.Client, error) {
+	if c, ok := options.Context.Value(clientKey{}).(*clientv3.Client); ok {
+		return c, nil
+	}
 
 	var endpoints []string
 
@@ -121,8 +188,59 @@ func NewSource(opts ...source.Option) source.Source {
 		config.Password = u.Password
 	}
 
-	// use default config
-	client, err := clientv3.New(config)
+	tlsConfig, err := newTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	config.TLS = tlsConfig
+
+	return clientv3.New(config)
+}
+
+// newTLSConfig returns the tls config of the options, or nil when no TLS
+// option is set
+func newTLSConfig(options source.Options) (*tls.Config, error) {
+	tv, ok := options.Context.Value(tlsKey{}).(*tlsValue)
+	if !ok {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+	if tv.cfg != nil {
+		cfg = tv.cfg.Clone()
+	}
+
+	if tv.caFile != "" {
+		b, err := os.ReadFile(tv.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates in %s", tv.caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if tv.certFile != "" || tv.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tv.certFile, tv.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+
+	if tv.serverName != "" {
+		cfg.ServerName = tv.serverName
+	}
+
+	return cfg, nil
+}
+
+func NewSource(opts ...source.Option) source.Source {
+	options := source.NewOptions(opts...)
+
+	client, err := newClient(options)
 
 	prefix := DefaultPrefix
 	sp := ""
@@ -135,7 +253,10 @@ func NewSource(opts ...source.Option) source.Source {
 		sp = prefix
 	}
 
+	guard, _ := options.Context.Value(checksumGuardKey{}).(bool)
+
 	return &etcd{
+		guard:       guard,
 		prefix:      prefix,
 		stripPrefix: sp,
 		opts:        options,
@@ -0,0 +1,561 @@
+package etcd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vine-io/vine/lib/config/source"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func newTestSource(t *testing.T, prefix string, opts ...source.Option) *etcd {
+	opts = append([]source.Option{WithAddress("127.0.0.1:2379"), WithPrefix(prefix)}, opts...)
+	c := NewSource(opts...).(*etcd)
+	if c.cerr != nil {
+		t.Skip(c.cerr)
+	}
+
+	ctx := context.TODO()
+	if _, err := c.client.Delete(ctx, prefix, clientv3.WithPrefix()); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() {
+		_, _ = c.client.Delete(ctx, prefix, clientv3.WithPrefix())
+		_ = c.client.Close()
+	})
+	return c
+}
+
+// equal compares the decoded values, the encoder does not sort the map keys
+func equal(c *etcd, a, b string) bool {
+	var va, vb interface{}
+	if c.opts.Encoder.Decode([]byte(a), &va) != nil || c.opts.Encoder.Decode([]byte(b), &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestEtcd_Write(t *testing.T) {
+	c := newTestSource(t, "/vine/test/write/", StripPrefix(true), WithChecksumGuard())
+
+	ctx := context.TODO()
+	if _, err := c.client.Put(ctx, "/vine/test/write/app/database", `{"host": "127.0.0.1", "port": 3306}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.client.Put(ctx, "/vine/test/write/app/cache", `{"host": "127.0.0.1"}`); err != nil {
+		t.Fatal(err)
+	}
+	cs := &source.ChangeSet{Data: []byte(`{"app": {"database": {"host": "10.0.0.1", "port": 3306}}, "broker": {"address": "nats"}}`)}
+	if err := c.Write(cs); err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := c.client.Get(ctx, "/vine/test/write/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs := map[string]string{}
+	for _, kv := range rsp.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	if len(kvs) != 2 {
+		t.Fatalf("unexpected keys %v", kvs)
+	}
+	if !equal(c, kvs["/vine/test/write/app/database"], `{"host": "10.0.0.1", "port": 3306}`) {
+		t.Fatalf("unexpected database %s", kvs["/vine/test/write/app/database"])
+	}
+	if !equal(c, kvs["/vine/test/write/"], `{"broker": {"address": "nats"}}`) {
+		t.Fatalf("unexpected broker %s", kvs["/vine/test/write/"])
+	}
+
+	// the checksum of the write is the one of the stored keys, a second write
+	// isn't taken for a conflict
+	if c.sum != checksum(rsp.Kvs) {
+		t.Fatal("checksum of the write differs from the stored keys")
+	}
+	cs2 := &source.ChangeSet{Data: []byte(`{"app": {"database": {"host": "10.0.0.1", "port": 3307}}, "broker": {"address": "nats"}}`)}
+	if err = c.Write(cs2); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Write(cs); err != nil {
+		t.Fatal(err)
+	}
+
+	rcs, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(c, string(rcs.Data), string(cs.Data)) {
+		t.Fatalf("unexpected config %s", rcs.Data)
+	}
+
+	// a concurrent edit is not clobbered
+	if _, err = c.client.Put(ctx, "/vine/test/write/app/database", `{"host": "10.0.0.2"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Write(cs); err != ErrConflict {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+}
+
+func TestEtcd_WritePrefix(t *testing.T) {
+	c := newTestSource(t, "/vine/test/prefix/")
+
+	cs := &source.ChangeSet{Data: []byte(`{"vine": {"test": {"prefix": {"app": {"name": "vine"}}}}}`)}
+	if err := c.Write(cs); err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := c.client.Get(context.TODO(), "/vine/test/prefix/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Kvs) != 1 || string(rsp.Kvs[0].Key) != "/vine/test/prefix/app" {
+		t.Fatalf("unexpected keys %v", rsp.Kvs)
+	}
+	if !equal(c, string(rsp.Kvs[0].Value), `{"name": "vine"}`) {
+		t.Fatalf("unexpected app %s", rsp.Kvs[0].Value)
+	}
+}
+
+func TestEtcd_WriteNested(t *testing.T) {
+	c := newTestSource(t, "/vine/test/nested/", StripPrefix(true))
+
+	ctx := context.TODO()
+	if _, err := c.client.Put(ctx, "/vine/test/nested/app/database", `{"host": "127.0.0.1"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new entry next to an existing key gets a key of its own
+	cs := &source.ChangeSet{Data: []byte(`{"app": {"database": {"host": "127.0.0.1"}, "cache": {"host": "10.0.0.1"}}, "name": "vine"}`)}
+	if err := c.Write(cs); err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := c.client.Get(ctx, "/vine/test/nested/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs := map[string]string{}
+	for _, kv := range rsp.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	if len(kvs) != 3 {
+		t.Fatalf("unexpected keys %v", kvs)
+	}
+	if !equal(c, kvs["/vine/test/nested/app/cache"], `{"host": "10.0.0.1"}`) {
+		t.Fatalf("unexpected cache %s", kvs["/vine/test/nested/app/cache"])
+	}
+	if !equal(c, kvs["/vine/test/nested/"], `{"name": "vine"}`) {
+		t.Fatalf("unexpected tree %s", kvs["/vine/test/nested/"])
+	}
+}
+
+func TestEtcd_ReadFormats(t *testing.T) {
+	c := newTestSource(t, "/vine/test/formats/", StripPrefix(true))
+
+	ctx := context.TODO()
+	for k, v := range map[string]string{
+		"/vine/test/formats/app/name":    "vine",
+		"/vine/test/formats/app/port":    "8080",
+		"/vine/test/formats/cfg/db.yaml": "host: 127.0.0.1\nport: 3306\n",
+	} {
+		if _, err := c.client.Put(ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cs, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(c, string(cs.Data), `{"app": {"name": "vine", "port": 8080}, "cfg": {"db": {"host": "127.0.0.1", "port": 3306}}}`) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+
+	cs = &source.ChangeSet{Data: []byte(`{"app": {"name": "vine-2", "port": 8080}, "cfg": {"db": {"host": "10.0.0.1", "port": 3306}}}`)}
+	if err = c.Write(cs); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := c.client.Get(ctx, "/vine/test/formats/app/name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(rsp.Kvs[0].Value); v != "vine-2" {
+		t.Fatalf("unexpected name %s", v)
+	}
+	rsp, err = c.client.Get(ctx, "/vine/test/formats/cfg/db.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(rsp.Kvs[0].Value); v != "host: 10.0.0.1\nport: 3306\n" {
+		t.Fatalf("unexpected db.yaml %q", v)
+	}
+
+	// a key in an invalid format fails the read
+	if _, err = c.client.Put(ctx, "/vine/test/formats/cfg/cache.json", `{"host": `); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Read(); err == nil {
+		t.Fatal("expected decode error")
+	}
+}
+
+func TestEtcd_KeyCollision(t *testing.T) {
+	c := newTestSource(t, "/vine/test/collision/", StripPrefix(true))
+
+	ctx := context.TODO()
+	if _, err := c.client.Put(ctx, "/vine/test/collision/cfg/db.yaml", "host: 127.0.0.1\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := c.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// a key of the same config path is reported by the watcher and fails
+	// the read and the write
+	if _, err = c.client.Put(ctx, "/vine/test/collision/cfg/db", `{"host": "10.0.0.1"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Next(); err == nil {
+		t.Fatal("expected collision error from the watcher")
+	}
+	if _, err = c.Read(); err == nil {
+		t.Fatal("expected collision error from the read")
+	}
+	cs := &source.ChangeSet{Data: []byte(`{"cfg": {"db": {"host": "10.0.0.2"}}}`)}
+	if err = c.Write(cs); err == nil {
+		t.Fatal("expected collision error from the write")
+	}
+
+	// deleting the colliding key leaves the config as it was
+	if _, err = c.client.Delete(ctx, "/vine/test/collision/cfg/db"); err != nil {
+		t.Fatal(err)
+	}
+	cs, err = w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(c, string(cs.Data), `{"cfg": {"db": {"host": "127.0.0.1"}}}`) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+}
+
+func TestEtcd_WriteRaw(t *testing.T) {
+	c := newTestSource(t, "/vine/test/raw/", StripPrefix(true))
+
+	ctx := context.TODO()
+	if _, err := c.client.Put(ctx, "/vine/test/raw/app/name", `"vine"`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.client.Put(ctx, "/vine/test/raw/app/motd", `hello`); err != nil {
+		t.Fatal(err)
+	}
+
+	// strings keep the way they are stored, new ones are encoded
+	cs := &source.ChangeSet{Data: []byte(`{"app": {"name": "plugins", "motd": "bye", "title": "vine plugins"}}`)}
+	if err := c.Write(cs); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"/vine/test/raw/app/name":  `"plugins"`,
+		"/vine/test/raw/app/motd":  `bye`,
+		"/vine/test/raw/app/title": `"vine plugins"`,
+	}
+	rsp, err := c.client.Get(ctx, "/vine/test/raw/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Kvs) != len(expected) {
+		t.Fatalf("unexpected keys %v", rsp.Kvs)
+	}
+	for _, kv := range rsp.Kvs {
+		if v := strings.TrimSpace(string(kv.Value)); v != expected[string(kv.Key)] {
+			t.Fatalf("unexpected value of %s: %s", kv.Key, v)
+		}
+	}
+
+	rcs, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(c, string(rcs.Data), string(cs.Data)) {
+		t.Fatalf("unexpected config %s", rcs.Data)
+	}
+}
+
+func TestEtcd_Watch(t *testing.T) {
+	c := newTestSource(t, "/vine/test/watch/", StripPrefix(true))
+
+	ctx := context.TODO()
+	if _, err := c.client.Put(ctx, "/vine/test/watch/app/name", `"vine"`); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := c.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	prsp, err := c.client.Put(ctx, "/vine/test/watch/app/port", `8080`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(c, string(cs.Data), `{"app": {"name": "vine", "port": 8080}}`) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+	if rev := w.(Revisioner).LastRevision(); rev != prsp.Header.Revision {
+		t.Fatalf("expected revision %d, got %d", prsp.Header.Revision, rev)
+	}
+}
+
+func TestEtcd_WatchAfterRead(t *testing.T) {
+	c := newTestSource(t, "/vine/test/watchread/", StripPrefix(true))
+
+	ctx := context.TODO()
+	if _, err := c.client.Put(ctx, "/vine/test/watchread/app/name", `"vine"`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the change between the read and the watch is sent by the watcher
+	prsp, err := c.client.Put(ctx, "/vine/test/watchread/app/port", `8080`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := c.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	cs, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(c, string(cs.Data), `{"app": {"name": "vine", "port": 8080}}`) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+	if rev := w.(Revisioner).LastRevision(); rev != prsp.Header.Revision {
+		t.Fatalf("expected revision %d, got %d", prsp.Header.Revision, rev)
+	}
+}
+
+func TestEtcd_WatchCompacted(t *testing.T) {
+	c := newTestSource(t, "/vine/test/compact/", StripPrefix(true))
+
+	ctx := context.TODO()
+	if _, err := c.client.Put(ctx, "/vine/test/compact/app/name", `"vine"`); err != nil {
+		t.Fatal(err)
+	}
+	rsp, cs, err := c.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prsp, err := c.client.Put(ctx, "/vine/test/compact/app/name", `"vine-2"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.client.Compact(ctx, prsp.Header.Revision); err != nil {
+		t.Fatal(err)
+	}
+
+	// the revisions after the read are gone, the keys are read again
+	if rev := c.LastRevision(); rev != rsp.Header.Revision {
+		t.Fatalf("expected revision %d, got %d", rsp.Header.Revision, rev)
+	}
+
+	w, err := newWatcher(c, cs, rsp.Kvs, rsp.Header.Revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	cs, err = w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(c, string(cs.Data), `{"app": {"name": "vine-2"}}`) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+	if cs.Source != c.String() {
+		t.Fatalf("unexpected source %s", cs.Source)
+	}
+	if rev := w.(Revisioner).LastRevision(); rev < prsp.Header.Revision {
+		t.Fatalf("unexpected revision %d", rev)
+	}
+}
+
+func TestEtcd_TLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "etcd"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	options := source.NewOptions(WithCA(certFile), WithCert(certFile, keyFile), WithServerName("etcd"))
+	cfg, err := newTLSConfig(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RootCAs == nil || len(cfg.Certificates) != 1 || cfg.ServerName != "etcd" {
+		t.Fatalf("unexpected tls config %+v", cfg)
+	}
+
+	if _, err = newTLSConfig(source.NewOptions(WithCA(keyFile))); err == nil {
+		t.Fatal("expected error for a CA file without certificates")
+	}
+	if cfg, err = newTLSConfig(source.NewOptions()); err != nil || cfg != nil {
+		t.Fatalf("unexpected tls config %+v %v", cfg, err)
+	}
+}
+
+func TestEtcd_WithClient(t *testing.T) {
+	c := newTestSource(t, "/vine/test/client/")
+
+	s := NewSource(WithClient(c.client), WithPrefix("/vine/test/client/"), WithAddress("unreachable:2379"))
+	if _, err := c.client.Put(context.TODO(), "/vine/test/client/app", `{"name": "vine"}`); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(c, string(cs.Data), `{"vine": {"test": {"client": {"app": {"name": "vine"}}}}}`) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+}
+
+func TestEtcd_History(t *testing.T) {
+	c := newTestSource(t, "/vine/test/history/", StripPrefix(true))
+
+	ctx := context.TODO()
+	put := func(key, val string) int64 {
+		rsp, err := c.client.Put(ctx, "/vine/test/history/"+key, val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rsp.Header.Revision
+	}
+
+	put("app/name", `"vine"`)
+	good := put("app/port", `8080`)
+	put("app/port", `0`)
+	drsp, err := c.client.Delete(ctx, "/vine/test/history/app/name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := drsp.Header.Revision
+
+	changes, err := c.Changes(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) < 4 {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	if changes[0].Revision != bad || len(changes[0].Deletes) != 1 || changes[0].Deletes[0] != "/vine/test/history/app/name" {
+		t.Fatalf("unexpected change %+v", changes[0])
+	}
+	if changes[2].Revision != good || changes[2].Puts[0] != "/vine/test/history/app/port" {
+		t.Fatalf("unexpected change %+v", changes[2])
+	}
+	if changes, err = c.Changes(ctx, 2); err != nil || len(changes) != 2 || changes[0].Revision != bad {
+		t.Fatalf("unexpected changes %+v %v", changes, err)
+	}
+
+	// the windows grow until the limit is reached, the revisions of other
+	// keys are skipped
+	window := historyWindow
+	historyWindow = 1
+	defer func() { historyWindow = window }()
+	for i := 0; i < 3; i++ {
+		if _, err = c.client.Put(ctx, "/vine/test/other/key", "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer c.client.Delete(ctx, "/vine/test/other/key")
+	if changes, err = c.Changes(ctx, 3); err != nil || len(changes) != 3 || changes[0].Revision != bad || changes[2].Revision != good {
+		t.Fatalf("unexpected changes %+v %v", changes, err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = c.Changes(cctx, 0); err == nil {
+		t.Fatal("expected error of the canceled context")
+	}
+
+	diffs, err := c.Diff(good, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []KeyDiff{
+		{Key: "/vine/test/history/app/name", Type: DiffRemoved, Old: `"vine"`},
+		{Key: "/vine/test/history/app/port", Type: DiffModified, Old: `8080`, New: `0`},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Fatalf("unexpected diffs %+v", diffs)
+	}
+
+	if err = c.Rollback(good); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(c, string(cs.Data), `{"app": {"name": "vine", "port": 8080}}`) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+	if diffs, err = c.Diff(good, 0); err != nil || len(diffs) != 0 {
+		t.Fatalf("unexpected diffs %+v %v", diffs, err)
+	}
+}
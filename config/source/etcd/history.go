@@ -0,0 +1,289 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/vine-io/vine/lib/config/source"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/metadata"
+)
+
+// History is implemented by the etcd source, it reads the config at the
+// revisions etcd keeps until they are compacted.
+//
+//	h := etcd.NewSource(etcd.WithPrefix("/vine/config/")).(etcd.History)
+type History interface {
+	// Changes returns the last changes of the keys under the prefix, newest
+	// first. The revisions are replayed backwards in growing windows until
+	// limit changes are found, a limit of 0 replays all revisions etcd keeps.
+	// The replay stops when the context is done.
+	Changes(ctx context.Context, limit int) ([]Change, error)
+	// ReadRevision reads the config at the revision
+	ReadRevision(rev int64) (*source.ChangeSet, error)
+	// Diff returns the keys which differ between two revisions, the revision
+	// 0 is the current one
+	Diff(from, to int64) ([]KeyDiff, error)
+	// Rollback writes the config of the revision
+	Rollback(rev int64) error
+}
+
+// Change is a revision which changed keys under the prefix
+type Change struct {
+	Revision int64
+	// Puts are the keys created or updated
+	Puts []string
+	// Deletes are the keys deleted
+	Deletes []string
+}
+
+// DiffType is the kind of a KeyDiff
+type DiffType int
+
+const (
+	DiffAdded DiffType = iota
+	DiffRemoved
+	DiffModified
+)
+
+func (t DiffType) String() string {
+	switch t {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffModified:
+		return "modified"
+	default:
+		return "unknown"
+	}
+}
+
+// KeyDiff is the change of a key between two revisions
+type KeyDiff struct {
+	Key  string
+	Type DiffType
+	// Old is the value at the first revision, New at the second one
+	Old string
+	New string
+}
+
+// progressInterval is the time between the requests for the progress of the
+// watch which replays the changes
+var progressInterval = time.Millisecond * 50
+
+// historyWindow is the number of revisions replayed first by Changes, each
+// following window is twice as large
+var historyWindow int64 = 1000
+
+func (c *etcd) Changes(ctx context.Context, limit int) ([]Change, error) {
+	if c.cerr != nil {
+		return nil, c.cerr
+	}
+
+	rsp, err := c.client.Get(ctx, c.prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	to, size := rsp.Header.Revision, historyWindow
+	for limit <= 0 || len(changes) < limit {
+		from := int64(1)
+		if limit > 0 && to > size {
+			from = to - size + 1
+		}
+
+		var window []Change
+		var compacted bool
+		for from <= to {
+			var rev int64
+			window, rev, err = c.replay(ctx, from, to)
+			if err != nil {
+				return nil, err
+			}
+			if rev == 0 {
+				break
+			}
+			// the revisions before the compaction are gone
+			window, from, compacted = nil, rev, true
+		}
+
+		// newest first
+		for i := len(window) - 1; i >= 0; i-- {
+			changes = append(changes, window[i])
+		}
+		if from <= 1 || compacted {
+			// no older revisions left
+			break
+		}
+		to, size = from-1, size*2
+	}
+
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+// replay watches the changes between the revisions, it returns the compact
+// revision when the changes from the first revision were compacted.
+func (c *etcd) replay(ctx context.Context, from, to int64) ([]Change, int64, error) {
+	var changes []Change
+	add := func(ev *clientv3.Event) {
+		rev := ev.Kv.ModRevision
+		if n := len(changes); n == 0 || changes[n-1].Revision != rev {
+			changes = append(changes, Change{Revision: rev})
+		}
+		change := &changes[len(changes)-1]
+		if ev.Type == clientv3.EventTypeDelete {
+			change.Deletes = append(change.Deletes, string(ev.Kv.Key))
+		} else {
+			change.Puts = append(change.Puts, string(ev.Kv.Key))
+		}
+	}
+
+	for wait := progressInterval; from <= to; wait *= 2 {
+		var compacted int64
+		var err error
+		if from, compacted, err = c.watchTo(ctx, from, to, wait, add); err != nil || compacted != 0 {
+			return nil, compacted, err
+		}
+	}
+	return changes, 0, nil
+}
+
+// replays counts the replay watches, each one has a stream of its own
+var replays int64
+
+// watchTo adds the changes from the revision up to the last one, it returns
+// the revision to watch again from when the progress was not reported in
+// time. etcd drops the progress request when the watch did not catch up yet
+// and no event follows, so the request is made once after the wait on a new
+// stream.
+func (c *etcd) watchTo(ctx context.Context, from, to int64, wait time.Duration, add func(*clientv3.Event)) (int64, int64, error) {
+	id := strconv.FormatInt(atomic.AddInt64(&replays, 1), 10)
+	ctx = metadata.AppendToOutgoingContext(ctx, "vine-replay", id)
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	wch := c.client.Watch(ctx, c.prefix, clientv3.WithPrefix(), clientv3.WithRev(from))
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var requested bool
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		case <-timer.C:
+			if requested {
+				return from, 0, nil
+			}
+			requested = true
+			_ = c.client.RequestProgress(ctx)
+			timer.Reset(wait)
+		case rsp, ok := <-wch:
+			if !ok {
+				return 0, 0, ctx.Err()
+			}
+			if rsp.CompactRevision != 0 {
+				return 0, rsp.CompactRevision, nil
+			}
+			if err := rsp.Err(); err != nil {
+				return 0, 0, err
+			}
+			if rsp.IsProgressNotify() && rsp.Header.Revision >= to {
+				return to + 1, 0, nil
+			}
+
+			// the events of a revision come in one response
+			for _, ev := range rsp.Events {
+				if ev.Kv.ModRevision > to {
+					return to + 1, 0, nil
+				}
+				add(ev)
+				from = ev.Kv.ModRevision + 1
+			}
+			if from > to {
+				return from, 0, nil
+			}
+		}
+	}
+}
+
+// get returns the keys under the prefix at the revision, 0 is the current one
+func (c *etcd) get(rev int64) (*clientv3.GetResponse, error) {
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev))
+	}
+	return c.client.Get(context.Background(), c.prefix, opts...)
+}
+
+func (c *etcd) ReadRevision(rev int64) (*source.ChangeSet, error) {
+	if c.cerr != nil {
+		return nil, c.cerr
+	}
+
+	rsp, err := c.get(rev)
+	if err != nil {
+		return nil, err
+	}
+	return c.changeSet(rsp.Kvs)
+}
+
+func (c *etcd) Diff(from, to int64) ([]KeyDiff, error) {
+	if c.cerr != nil {
+		return nil, c.cerr
+	}
+
+	frsp, err := c.get(from)
+	if err != nil {
+		return nil, err
+	}
+	trsp, err := c.get(to)
+	if err != nil {
+		return nil, err
+	}
+
+	old := make(map[string]*mvccpb.KeyValue, len(frsp.Kvs))
+	for _, kv := range frsp.Kvs {
+		old[string(kv.Key)] = kv
+	}
+
+	var diffs []KeyDiff
+	for _, kv := range trsp.Kvs {
+		key := string(kv.Key)
+		okv, ok := old[key]
+		delete(old, key)
+		switch {
+		case !ok:
+			diffs = append(diffs, KeyDiff{Key: key, Type: DiffAdded, New: string(kv.Value)})
+		case !bytes.Equal(okv.Value, kv.Value):
+			diffs = append(diffs, KeyDiff{Key: key, Type: DiffModified, Old: string(okv.Value), New: string(kv.Value)})
+		}
+	}
+	for key, kv := range old {
+		diffs = append(diffs, KeyDiff{Key: key, Type: DiffRemoved, Old: string(kv.Value)})
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Key < diffs[j].Key
+	})
+	return diffs, nil
+}
+
+func (c *etcd) Rollback(rev int64) error {
+	cs, err := c.ReadRevision(rev)
+	if err != nil {
+		return err
+	}
+	return c.Write(cs)
+}
@@ -2,9 +2,11 @@ package etcd
 
 import (
 	"context"
+	"crypto/tls"
 	"time"
 
 	"github.com/vine-io/vine/lib/config/source"
+	"go.etcd.io/etcd/client/v3"
 )
 
 type addressKey struct{}
@@ -12,12 +14,36 @@ type prefixKey struct{}
 type stripPrefixKey struct{}
 type authKey struct{}
 type dialTimeoutKey struct{}
+type checksumGuardKey struct{}
+type clientKey struct{}
+type tlsKey struct{}
 
 type authCreds struct {
 	Username string
 	Password string
 }
 
+type tlsValue struct {
+	cfg        *tls.Config
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+}
+
+// tlsOption updates a copy of the TLS options set so far
+func tlsOption(o *source.Options, fn func(tv *tlsValue)) {
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+	tv := &tlsValue{}
+	if v, ok := o.Context.Value(tlsKey{}).(*tlsValue); ok {
+		*tv = *v
+	}
+	fn(tv)
+	o.Context = context.WithValue(o.Context, tlsKey{}, tv)
+}
+
 // WithAddress sets the etcd address
 func WithAddress(a ...string) source.Option {
 	return func(o *source.Options) {
@@ -67,4 +93,66 @@ func WithDialTimeout(timeout time.Duration) source.Option {
 		}
 		o.Context = context.WithValue(o.Context, dialTimeoutKey{}, timeout)
 	}
-}
\ No newline at end of file
+}
+
+// WithChecksumGuard makes Write fail with ErrConflict when the config was
+// changed since it was last read or written by the source
+func WithChecksumGuard() source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, checksumGuardKey{}, true)
+	}
+}
+
+// WithTLS sets the tls config of the etcd client, the other TLS options are
+// applied to a copy of it
+func WithTLS(cfg *tls.Config) source.Option {
+	return func(o *source.Options) {
+		tlsOption(o, func(tv *tlsValue) {
+			tv.cfg = cfg
+		})
+	}
+}
+
+// WithCA sets the PEM file of the certificate authorities which verify the
+// etcd servers
+func WithCA(caFile string) source.Option {
+	return func(o *source.Options) {
+		tlsOption(o, func(tv *tlsValue) {
+			tv.caFile = caFile
+		})
+	}
+}
+
+// WithCert sets the PEM files of the client certificate and its key
+func WithCert(certFile, keyFile string) source.Option {
+	return func(o *source.Options) {
+		tlsOption(o, func(tv *tlsValue) {
+			tv.certFile = certFile
+			tv.keyFile = keyFile
+		})
+	}
+}
+
+// WithServerName sets the name which the certificates of the etcd servers
+// are verified for
+func WithServerName(name string) source.Option {
+	return func(o *source.Options) {
+		tlsOption(o, func(tv *tlsValue) {
+			tv.serverName = name
+		})
+	}
+}
+
+// WithClient sets an existing etcd client, e.g. the one of the registry. The
+// address, auth, dial timeout and TLS options are ignored then.
+func WithClient(c *clientv3.Client) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, clientKey{}, c)
+	}
+}
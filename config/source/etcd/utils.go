@@ -1,49 +1,153 @@
 package etcd
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/vine-io/vine/lib/config/encoder"
+	"github.com/vine-io/vine/lib/config/encoder/hcl"
+	"github.com/vine-io/vine/lib/config/encoder/json"
+	"github.com/vine-io/vine/lib/config/encoder/toml"
+	"github.com/vine-io/vine/lib/config/encoder/yaml"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/client/v3"
 )
 
-func makeEvMap(e encoder.Encoder, data map[string]interface{}, kv []*clientv3.Event, stripPrefix string) map[string]interface{} {
+// encoders decode the keys with the suffix of their format, e.g. ".yaml"
+var encoders = map[string]encoder.Encoder{
+	"json": json.NewEncoder(),
+	"yaml": yaml.NewEncoder(),
+	"yml":  yaml.NewEncoder(),
+	"toml": toml.NewEncoder(),
+	"hcl":  hcl.NewEncoder(),
+}
+
+// keyFormat returns the key without its format suffix and the encoder of the
+// format, or the key and nil when the key has no known suffix
+func keyFormat(key string) (string, encoder.Encoder) {
+	i := strings.LastIndex(key, ".")
+	if i == -1 || strings.Contains(key[i:], "/") {
+		return key, nil
+	}
+	if e, ok := encoders[key[i+1:]]; ok {
+		return key[:i], e
+	}
+	return key, nil
+}
+
+// decode returns the value of the key. A key with a format suffix must be
+// valid in the format, any other value which is not valid in the encoding
+// of the source is the raw string.
+func decode(e encoder.Encoder, key string, value []byte) (interface{}, error) {
+	var vals interface{}
+	if _, fe := keyFormat(key); fe != nil {
+		if err := fe.Decode(value, &vals); err != nil {
+			return nil, fmt.Errorf("error decoding %s: %v", key, err)
+		}
+		return vals, nil
+	}
+
+	if err := e.Decode(value, &vals); err != nil {
+		return string(value), nil
+	}
+	return vals, nil
+}
+
+// configPath returns the path of the key in the config tree, without the
+// stripped prefix and the format suffix
+func configPath(key, stripPrefix string) string {
+	// remove prefix if non empty, and ensure leading / is removed as well
+	path := strings.TrimPrefix(strings.TrimPrefix(key, stripPrefix), "/")
+	// remove the format suffix
+	path, _ = keyFormat(path)
+	return path
+}
+
+// keyPaths returns the keys by their config path. Keys which only differ in
+// the format suffix, e.g. "db" and "db.yaml", hold the same path and are an
+// error as one would silently replace the other.
+func keyPaths(kvs []*mvccpb.KeyValue, stripPrefix string) (map[string]string, error) {
+	keys := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		if err := addPath(keys, string(kv.Key), stripPrefix); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// addPath records the config path of the key, see keyPaths
+func addPath(keys map[string]string, key, stripPrefix string) error {
+	path := configPath(key, stripPrefix)
+	if k, ok := keys[path]; ok && k != key {
+		return fmt.Errorf("keys %s and %s hold the same config path", k, key)
+	}
+	keys[path] = key
+	return nil
+}
+
+// makeEvMap applies the events to the data. The keys by their config path
+// are updated with the events, see keyPaths.
+func makeEvMap(e encoder.Encoder, data map[string]interface{}, keys map[string]string, kv []*clientv3.Event, stripPrefix string) (map[string]interface{}, error) {
 	if data == nil {
 		data = make(map[string]interface{})
 	}
 
+	var err error
 	for _, v := range kv {
+		key := string(v.Kv.Key)
 		switch mvccpb.Event_EventType(v.Type) {
 		case mvccpb.DELETE:
-			data = update(e, data, (*mvccpb.KeyValue)(v.Kv), "delete", stripPrefix)
+			if keys[configPath(key, stripPrefix)] != key {
+				// not the key of the path, nothing to delete
+				continue
+			}
+			delete(keys, configPath(key, stripPrefix))
+			data, err = update(e, data, (*mvccpb.KeyValue)(v.Kv), "delete", stripPrefix)
 		default:
-			data = update(e, data, (*mvccpb.KeyValue)(v.Kv), "insert", stripPrefix)
+			if err = addPath(keys, key, stripPrefix); err != nil {
+				return nil, err
+			}
+			data, err = update(e, data, (*mvccpb.KeyValue)(v.Kv), "insert", stripPrefix)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return data
+	return data, nil
 }
 
-func makeMap(e encoder.Encoder, kv []*mvccpb.KeyValue, stripPrefix string) map[string]interface{} {
+func makeMap(e encoder.Encoder, kv []*mvccpb.KeyValue, stripPrefix string) (map[string]interface{}, error) {
+	if _, err := keyPaths(kv, stripPrefix); err != nil {
+		return nil, err
+	}
+
 	data := make(map[string]interface{})
 
+	var err error
 	for _, v := range kv {
-		data = update(e, data, v, "put", stripPrefix)
+		if data, err = update(e, data, v, "put", stripPrefix); err != nil {
+			return nil, err
+		}
 	}
 
-	return data
+	return data, nil
 }
 
-func update(e encoder.Encoder, data map[string]interface{}, v *mvccpb.KeyValue, action, stripPrefix string) map[string]interface{} {
-	// remove prefix if non empty, and ensure leading / is removed as well
-	vkey := strings.TrimPrefix(strings.TrimPrefix(string(v.Key), stripPrefix), "/")
+func update(e encoder.Encoder, data map[string]interface{}, v *mvccpb.KeyValue, action, stripPrefix string) (map[string]interface{}, error) {
+	vkey := configPath(string(v.Key), stripPrefix)
 	// split on prefix
 	haveSplit := strings.Contains(vkey, "/")
 	keys := strings.Split(vkey, "/")
 
 	var vals interface{}
-	e.Decode(v.Value, &vals)
+	if action != "delete" {
+		var err error
+		if vals, err = decode(e, string(v.Key), v.Value); err != nil {
+			return nil, err
+		}
+	}
 
 	if !haveSplit && len(keys) == 1 {
 		switch action {
@@ -55,7 +159,7 @@ func update(e encoder.Encoder, data map[string]interface{}, v *mvccpb.KeyValue,
 				data = v
 			}
 		}
-		return data
+		return data, nil
 	}
 
 	// set data for first iteration
@@ -85,5 +189,5 @@ func update(e encoder.Encoder, data map[string]interface{}, v *mvccpb.KeyValue,
 		kvals = kval
 	}
 
-	return data
+	return data, nil
 }
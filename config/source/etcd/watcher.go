@@ -7,39 +7,67 @@ import (
 	"time"
 
 	"github.com/vine-io/vine/lib/config/source"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
+// retryInterval is the time between the attempts to watch again
+var retryInterval = time.Second
+
 type watcher struct {
-	opts        source.Options
-	name        string
-	stripPrefix string
+	c    *etcd
+	opts source.Options
 
 	sync.RWMutex
 	cs *source.ChangeSet
-
-	ch   chan *source.ChangeSet
+	// rev is the last revision applied to cs
+	rev int64
+	// last is the revision of the last changeset returned by Next
+	last int64
+	// keys holds the keys of cs by their config path, it is only used by
+	// the goroutine which applies the changes
+	keys map[string]string
+
+	ch   chan revChangeSet
+	errs chan error
 	exit chan bool
 }
 
-func newWatcher(key, strip string, wc clientv3.Watcher, cs *source.ChangeSet, opts source.Options) (source.Watcher, error) {
+// revChangeSet is a changeset and its revision
+type revChangeSet struct {
+	cs  *source.ChangeSet
+	rev int64
+}
+
+func newWatcher(c *etcd, cs *source.ChangeSet, kvs []*mvccpb.KeyValue, rev int64) (source.Watcher, error) {
+	keys, err := keyPaths(kvs, c.stripPrefix)
+	if err != nil {
+		return nil, err
+	}
+
 	w := &watcher{
-		opts:        opts,
-		name:        "etcd",
-		stripPrefix: strip,
-		cs:          cs,
-		ch:          make(chan *source.ChangeSet),
-		exit:        make(chan bool),
+		c:    c,
+		opts: c.opts,
+		cs:   cs,
+		rev:  rev,
+		keys: keys,
+		ch:   make(chan revChangeSet),
+		errs: make(chan error),
+		exit: make(chan bool),
 	}
 
-	ch := wc.Watch(context.Background(), key, clientv3.WithPrefix())
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-w.exit
+		cancel()
+	}()
 
-	go w.run(wc, ch)
+	go w.run(ctx)
 
 	return w, nil
 }
 
-func (w *watcher) handle(evs []*clientv3.Event) {
+func (w *watcher) handle(evs []*clientv3.Event, rev int64) error {
 	w.RLock()
 	data := w.cs.Data
 	w.RUnlock()
@@ -48,60 +76,152 @@ func (w *watcher) handle(evs []*clientv3.Event) {
 
 	// unpackage existing changeset
 	if err := w.opts.Encoder.Decode(data, &vals); err != nil {
-		return
+		return err
 	}
 
-	// update base changeset
-	d := makeEvMap(w.opts.Encoder, vals, evs, w.stripPrefix)
+	// update base changeset, the keys are kept when the events can't be applied
+	keys := make(map[string]string, len(w.keys))
+	for path, key := range w.keys {
+		keys[path] = key
+	}
+	d, err := makeEvMap(w.opts.Encoder, vals, keys, evs, w.c.stripPrefix)
+	if err != nil {
+		return err
+	}
+	w.keys = keys
 
 	// pack the changeset
 	b, err := w.opts.Encoder.Encode(d)
 	if err != nil {
-		return
+		return err
 	}
 
 	// create new changeset
 	cs := &source.ChangeSet{
 		Timestamp: time.Now(),
-		Source:    w.name,
+		Source:    w.c.String(),
 		Data:      b,
 		Format:    w.opts.Encoder.String(),
 	}
 	cs.CheckSum = cs.Sum()
 
-	// set base change set
+	w.send(cs, rev)
+	return nil
+}
+
+// reload reads all keys again when the revisions to watch were compacted
+func (w *watcher) reload(ctx context.Context) error {
+	rsp, err := w.c.client.Get(ctx, w.c.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	cs, err := w.c.changeSet(rsp.Kvs)
+	if err != nil {
+		return err
+	}
+	if w.keys, err = keyPaths(rsp.Kvs, w.c.stripPrefix); err != nil {
+		return err
+	}
+
+	w.send(cs, rsp.Header.Revision)
+	return nil
+}
+
+// send sets the base changeset at the revision and sends it
+func (w *watcher) send(cs *source.ChangeSet, rev int64) {
 	w.Lock()
 	w.cs = cs
+	w.rev = rev
 	w.Unlock()
 
-	// send update
-	w.ch <- cs
+	select {
+	case w.ch <- revChangeSet{cs: cs, rev: rev}:
+	case <-w.exit:
+	}
+}
+
+func (w *watcher) fail(err error) {
+	select {
+	case w.errs <- err:
+	case <-w.exit:
+	}
 }
 
-func (w *watcher) run(wc clientv3.Watcher, ch clientv3.WatchChan) {
+// run watches the prefix from the last applied revision, the watch is
+// started again when it failed
+func (w *watcher) run(ctx context.Context) {
 	for {
+		if w.watch(ctx) {
+			continue
+		}
+
 		select {
-		case rsp, ok := <-ch:
-			if !ok {
-				return
-			}
-			w.handle(rsp.Events)
-		case <-w.exit:
-			wc.Close()
+		case <-ctx.Done():
 			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// watch applies the changes after the last applied revision until the watch
+// fails, it returns true when the watch can be started again at once
+func (w *watcher) watch(ctx context.Context) bool {
+	w.RLock()
+	rev := w.rev
+	w.RUnlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wch := w.c.client.Watch(clientv3.WithRequireLeader(ctx), w.c.prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+	for rsp := range wch {
+		if rsp.CompactRevision != 0 {
+			if err := w.reload(ctx); err != nil {
+				if ctx.Err() == nil {
+					w.fail(err)
+				}
+				return false
+			}
+			return true
+		}
+		if rsp.Err() != nil {
+			return false
+		}
+		if len(rsp.Events) == 0 {
+			continue
+		}
+		if err := w.handle(rsp.Events, rsp.Header.Revision); err != nil {
+			// skip the events which can't be applied
+			w.Lock()
+			w.rev = rsp.Header.Revision
+			w.Unlock()
+			w.fail(err)
 		}
 	}
+	return false
 }
 
 func (w *watcher) Next() (*source.ChangeSet, error) {
 	select {
-	case cs := <-w.ch:
-		return cs, nil
+	case u := <-w.ch:
+		w.Lock()
+		w.last = u.rev
+		w.Unlock()
+		return u.cs, nil
+	case err := <-w.errs:
+		return nil, err
 	case <-w.exit:
 		return nil, errors.New("watcher stopped")
 	}
 }
 
+func (w *watcher) LastRevision() int64 {
+	w.RLock()
+	defer w.RUnlock()
+	return w.last
+}
+
 func (w *watcher) Stop() error {
 	select {
 	case <-w.exit:
@@ -0,0 +1,311 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/vine-io/vine/lib/config/source"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// ErrConflict is returned by Write when the config was changed since it was
+// last read by the source
+var ErrConflict = errors.New("config changed since last read")
+
+// Write stores the changeset as the keys under the prefix, reversing the way
+// Read merges the keys. Existing keys keep their place in the tree, the top
+// level entries which belong to no key are written to new keys and the keys
+// whose path was removed are deleted, all in one transaction.
+func (c *etcd) Write(cs *source.ChangeSet) error {
+	if c.cerr != nil {
+		return c.cerr
+	}
+
+	var data map[string]interface{}
+	if err := c.opts.Encoder.Decode(cs.Data, &data); err != nil {
+		return fmt.Errorf("error decoding changeset: %v", err)
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+
+	ctx := context.Background()
+	rsp, err := c.client.Get(ctx, c.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	if c.guard {
+		c.mu.Lock()
+		last := c.sum
+		c.mu.Unlock()
+		if last != "" && last != checksum(rsp.Kvs) {
+			return ErrConflict
+		}
+	}
+
+	cmps, ops, err := c.diff(data, rsp.Kvs)
+	if err != nil {
+		return err
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	txn := c.client.Txn(ctx)
+	if c.guard {
+		txn = txn.If(cmps...)
+	}
+	trsp, err := txn.Then(ops...).Commit()
+	if err != nil {
+		return err
+	}
+	if !trsp.Succeeded {
+		return ErrConflict
+	}
+
+	// remember the written state for the next guarded write, the comparisons
+	// ensure the keys were unchanged when the operations were applied to them
+	c.mu.Lock()
+	c.sum = checksum(apply(rsp.Kvs, ops))
+	c.mu.Unlock()
+	return nil
+}
+
+// apply returns the keys after the puts and deletes of the operations, sorted
+// by key as etcd returns them
+func apply(kvs []*mvccpb.KeyValue, ops []clientv3.Op) []*mvccpb.KeyValue {
+	values := make(map[string][]byte, len(kvs))
+	for _, kv := range kvs {
+		values[string(kv.Key)] = kv.Value
+	}
+	for _, op := range ops {
+		switch {
+		case op.IsPut():
+			values[string(op.KeyBytes())] = op.ValueBytes()
+		case op.IsDelete():
+			delete(values, string(op.KeyBytes()))
+		}
+	}
+
+	out := make([]*mvccpb.KeyValue, 0, len(values))
+	for k, v := range values {
+		out = append(out, &mvccpb.KeyValue{Key: []byte(k), Value: v})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return string(out[i].Key) < string(out[j].Key)
+	})
+	return out
+}
+
+// treePath returns the path of the key in the config tree, as built by update.
+// A key without a path holds the whole tree.
+func (c *etcd) treePath(key string) []string {
+	rel := configPath(key, c.stripPrefix)
+	if !strings.Contains(rel, "/") {
+		return nil
+	}
+	return strings.Split(rel, "/")
+}
+
+// encode returns the value of the key in the format of its suffix or the
+// encoding of the source. A string stored raw stays raw, as it is read back.
+func (c *etcd) encode(kv *mvccpb.KeyValue, key string, val interface{}) ([]byte, error) {
+	if _, e := keyFormat(key); e != nil {
+		return e.Encode(val)
+	}
+	if s, ok := val.(string); ok && kv != nil {
+		var v interface{}
+		if c.opts.Encoder.Decode(kv.Value, &v) != nil && c.opts.Encoder.Decode([]byte(s), &v) != nil {
+			return []byte(s), nil
+		}
+	}
+	return c.opts.Encoder.Encode(val)
+}
+
+// diff returns the operations which turn the keys into the data, and the
+// comparisons which guard them against concurrent changes.
+func (c *etcd) diff(data map[string]interface{}, kvs []*mvccpb.KeyValue) ([]clientv3.Cmp, []clientv3.Op, error) {
+	if _, err := keyPaths(kvs, c.stripPrefix); err != nil {
+		return nil, nil, err
+	}
+
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+
+	put := func(key string, kv *mvccpb.KeyValue, val interface{}) error {
+		if kv != nil {
+			if v, err := decode(c.opts.Encoder, key, kv.Value); err == nil && reflect.DeepEqual(v, val) {
+				return nil
+			}
+		}
+		b, err := c.encode(kv, key, val)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, clientv3.OpPut(key, string(b)))
+		return nil
+	}
+
+	var paths [][]string
+	var whole []*mvccpb.KeyValue
+	for _, kv := range kvs {
+		key := string(kv.Key)
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision))
+
+		path := c.treePath(key)
+		if path == nil {
+			whole = append(whole, kv)
+			continue
+		}
+		paths = append(paths, path)
+
+		val, ok := lookup(data, path)
+		if !ok {
+			ops = append(ops, clientv3.OpDelete(key))
+			continue
+		}
+		if err := put(key, kv, val); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// the tree under the prefix, the paths of the keys start with the prefix
+	// unless it is stripped
+	var root []string
+	if c.stripPrefix == "" {
+		root = c.treePath(strings.TrimSuffix(c.prefix, "/"))
+	}
+
+	var add func(rel []string, v interface{}) error
+	add = func(rel []string, v interface{}) error {
+		path := strings.Join(append(append([]string{}, root...), rel...), "/")
+		var descendant bool
+		for _, p := range paths {
+			p := strings.Join(p, "/")
+			if p == path || strings.HasPrefix(path, p+"/") {
+				// stored by an existing key
+				return nil
+			}
+			if strings.HasPrefix(p, path+"/") {
+				descendant = true
+			}
+		}
+
+		if child, ok := v.(map[string]interface{}); ok && descendant {
+			for _, name := range sortedKeys(child) {
+				if err := add(append(rel, name), child[name]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if c.stripPrefix != "" && len(rel) == 1 {
+			// a key right under the stripped prefix holds the whole tree
+			return nil
+		}
+
+		key := strings.TrimSuffix(c.prefix, "/") + "/" + strings.Join(rel, "/")
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+		paths = append(paths, append(append([]string{}, root...), rel...))
+		return put(key, nil, v)
+	}
+
+	if node, ok := lookup(data, root); ok {
+		if m, ok := node.(map[string]interface{}); ok {
+			for _, name := range sortedKeys(m) {
+				if err := add([]string{name}, m[name]); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	// the keys of the whole tree hold what no other key stores
+	rest := prune(data, paths)
+	for _, kv := range whole {
+		if len(rest) == 0 {
+			ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+			continue
+		}
+		if err := put(string(kv.Key), kv, rest); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(whole) == 0 && c.stripPrefix != "" && len(rest) > 0 {
+		// the prefix itself is read before the other keys
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(c.prefix), "=", 0))
+		if err := put(c.prefix, nil, rest); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return cmps, ops, nil
+}
+
+// prune returns a copy of the tree without the paths, the maps emptied by it
+// are removed as well
+func prune(data map[string]interface{}, paths [][]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		out[k] = v
+	}
+
+	children := make(map[string][][]string)
+	for _, p := range paths {
+		if len(p) == 1 {
+			delete(out, p[0])
+			continue
+		}
+		children[p[0]] = append(children[p[0]], p[1:])
+	}
+	for k, sub := range children {
+		m, ok := out[k].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if m = prune(m, sub); len(m) == 0 {
+			delete(out, k)
+		} else {
+			out[k] = m
+		}
+	}
+	return out
+}
+
+// lookup returns the value at the path of the tree, the empty path is the
+// whole tree
+func lookup(data map[string]interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return data, true
+	}
+
+	var node interface{} = data
+	for _, k := range path {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		node, ok = m[k]
+		if !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,104 @@
+package secret
+
+import (
+	"context"
+
+	"github.com/vine-io/vine/lib/config/source"
+	"k8s.io/client-go/kubernetes"
+)
+
+type configPathKey struct{}
+type nameKey struct{}
+type namespaceKey struct{}
+type labelSelectorKey struct{}
+type binaryKeysKey struct{}
+type clientKey struct{}
+type formatsKey struct{}
+
+// WithNamespace is an option to add namespace of secret
+func WithNamespace(s string) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, namespaceKey{}, s)
+	}
+}
+
+// WithName is an option to add name of secret
+func WithName(s string) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, nameKey{}, s)
+	}
+}
+
+// WithLabelSelector is an option to merge all secrets matching the selector,
+// e.g. "app=vine", instead of reading the secret of WithName
+func WithLabelSelector(s string) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, labelSelectorKey{}, s)
+	}
+}
+
+// WithConfigPath option for setting a custom path to kubeconfig
+func WithConfigPath(s string) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, configPathKey{}, s)
+	}
+}
+
+// WithClient option for using an existing kubernetes client instead of the
+// in cluster or kubeconfig one
+func WithClient(c kubernetes.Interface) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, clientKey{}, c)
+	}
+}
+
+// WithKeyFormat is an option to set the format of the document in a key of
+// the secret, e.g. "yaml". By default it is the extension of the key.
+func WithKeyFormat(key, format string) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		formats := map[string]string{key: format}
+		if f, ok := o.Context.Value(formatsKey{}).(map[string]string); ok {
+			for k, v := range f {
+				if k != key {
+					formats[k] = v
+				}
+			}
+		}
+		o.Context = context.WithValue(o.Context, formatsKey{}, formats)
+	}
+}
+
+// WithBinaryKey is an option to read the value of a key of the secret base64
+// encoded, e.g. for a certificate. The values of the other keys are strings.
+func WithBinaryKey(key string) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		keys := map[string]bool{key: true}
+		if k, ok := o.Context.Value(binaryKeysKey{}).(map[string]bool); ok {
+			for v := range k {
+				keys[v] = true
+			}
+		}
+		o.Context = context.WithValue(o.Context, binaryKeysKey{}, keys)
+	}
+}
@@ -0,0 +1,169 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/vine-io/vine/lib/config/source"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Predefined variables
+var (
+	DefaultName       = "vine"
+	DefaultConfigPath = ""
+	DefaultNamespace  = "default"
+)
+
+type secret struct {
+	opts       source.Options
+	client     kubernetes.Interface
+	cerr       error
+	name       string
+	namespace  string
+	selector   string
+	configPath string
+	formats    map[string]string
+	binary     map[string]bool
+}
+
+func (k *secret) Read() (*source.ChangeSet, error) {
+	if k.cerr != nil {
+		return nil, k.cerr
+	}
+
+	secrets, err := k.list()
+	if err != nil {
+		return nil, err
+	}
+
+	return k.changeSet(secrets)
+}
+
+// list returns the secret of the name, or the secrets matching the selector
+// sorted by name
+func (k *secret) list() ([]*corev1.Secret, error) {
+	ctx := context.TODO()
+	client := k.client.CoreV1().Secrets(k.namespace)
+
+	if k.selector == "" {
+		s, err := client.Get(ctx, k.name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return []*corev1.Secret{s}, nil
+	}
+
+	list, err := client.List(ctx, v1.ListOptions{LabelSelector: k.selector})
+	if err != nil {
+		return nil, err
+	}
+	secrets := make([]*corev1.Secret, 0, len(list.Items))
+	for i := range list.Items {
+		secrets = append(secrets, &list.Items[i])
+	}
+	sortSecrets(secrets)
+	return secrets, nil
+}
+
+func (k *secret) changeSet(secrets []*corev1.Secret) (*source.ChangeSet, error) {
+	data, err := makeMap(secrets, k.formats, k.binary)
+	if err != nil {
+		return nil, fmt.Errorf("error reading source: %v", err)
+	}
+
+	b, err := k.opts.Encoder.Encode(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading source: %v", err)
+	}
+
+	cs := &source.ChangeSet{
+		Format:    k.opts.Encoder.String(),
+		Source:    k.String(),
+		Data:      b,
+		Timestamp: lastModified(secrets),
+	}
+	cs.CheckSum = cs.Sum()
+
+	return cs, nil
+}
+
+// Write is unsupported, secrets are managed outside of the service
+func (k *secret) Write(cs *source.ChangeSet) error {
+	return errors.New("secret source is read only")
+}
+
+func (k *secret) String() string {
+	return "secret"
+}
+
+func (k *secret) Watch() (source.Watcher, error) {
+	if k.cerr != nil {
+		return nil, k.cerr
+	}
+
+	w, err := newWatcher(k)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func sortSecrets(secrets []*corev1.Secret) {
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+}
+
+// NewSource is a factory function
+func NewSource(opts ...source.Option) source.Source {
+	var (
+		options    = source.NewOptions(opts...)
+		name       = DefaultName
+		configPath = DefaultConfigPath
+		namespace  = DefaultNamespace
+	)
+
+	cfg, ok := options.Context.Value(configPathKey{}).(string)
+	if ok {
+		configPath = cfg
+	}
+
+	sname, ok := options.Context.Value(nameKey{}).(string)
+	if ok {
+		name = sname
+	}
+
+	ns, ok := options.Context.Value(namespaceKey{}).(string)
+	if ok {
+		namespace = ns
+	}
+
+	selector, _ := options.Context.Value(labelSelectorKey{}).(string)
+	binary, _ := options.Context.Value(binaryKeysKey{}).(map[string]bool)
+	formats, _ := options.Context.Value(formatsKey{}).(map[string]string)
+
+	var client kubernetes.Interface
+	var err error
+	if c, ok := options.Context.Value(clientKey{}).(kubernetes.Interface); ok {
+		client = c
+	} else {
+		client, err = getClient(configPath)
+	}
+
+	return &secret{
+		cerr:       err,
+		client:     client,
+		opts:       options,
+		name:       name,
+		namespace:  namespace,
+		selector:   selector,
+		configPath: configPath,
+		formats:    formats,
+		binary:     binary,
+	}
+}
@@ -0,0 +1,184 @@
+package secret
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/vine-io/vine/lib/config/source"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func decode(t *testing.T, s source.Source, cs *source.ChangeSet) map[string]interface{} {
+	var data map[string]interface{}
+	if err := s.(*secret).opts.Encoder.Decode(cs.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSecret_Read(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "vine", Namespace: "default"},
+		Data: map[string][]byte{
+			"password": []byte("s3cret"),
+			"key":      {0xff, 0x00},
+			"cert":     []byte("abc"),
+			"db.yaml":  []byte("database:\n  user: vine\n"),
+		},
+		StringData: map[string]string{"token": "abc"},
+	})
+	s := NewSource(WithClient(client), WithBinaryKey("key"), WithBinaryKey("cert"))
+
+	cs, err := s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"password": "s3cret",
+		"key":      "/wA=",
+		"cert":     "YWJj",
+		"token":    "abc",
+		"database": map[string]interface{}{"user": "vine"},
+	}
+	if data := decode(t, s, cs); !reflect.DeepEqual(data, expected) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+
+	if _, err = NewSource(WithClient(client), WithName("missing")).Read(); err == nil {
+		t.Fatal("expected error for a missing secret")
+	}
+}
+
+func TestSecret_LabelSelector(t *testing.T) {
+	labels := map[string]string{"app": "vine"}
+	client := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: "a", Namespace: "default", Labels: labels},
+			Data: map[string][]byte{
+				"config.json": []byte(`{"database": {"user": "vine", "password": "a"}}`),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: "b", Namespace: "default", Labels: labels},
+			Data:       map[string][]byte{"config.json": []byte(`{"database": {"password": "b"}}`)},
+		},
+		&corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: "c", Namespace: "default"},
+			Data:       map[string][]byte{"config.json": []byte(`{"database": {"password": "c"}}`)},
+		},
+	)
+	s := NewSource(WithClient(client), WithLabelSelector("app=vine"))
+
+	cs, err := s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"database": map[string]interface{}{"user": "vine", "password": "b"},
+	}
+	if data := decode(t, s, cs); !reflect.DeepEqual(data, expected) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+
+	w, err := s.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// the initial secrets are sent in one changeset
+	if cs, err = w.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if data := decode(t, s, cs); !reflect.DeepEqual(data, expected) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+
+	b := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "b", Namespace: "default", Labels: labels, ResourceVersion: "2"},
+		Data:       map[string][]byte{"config.json": []byte(`{"database": {"password": "b2"}}`)},
+	}
+	if _, err = client.CoreV1().Secrets("default").Update(context.TODO(), b, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if cs, err = w.Next(); err != nil {
+		t.Fatal(err)
+	}
+	expected["database"] = map[string]interface{}{"user": "vine", "password": "b2"}
+	if data := decode(t, s, cs); !reflect.DeepEqual(data, expected) {
+		t.Fatalf("unexpected config %s", cs.Data)
+	}
+}
+
+func TestSecret_Watch(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := NewSource(WithClient(client), WithLabelSelector("app=vine"))
+
+	w, err := s.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	next := func(expected map[string]interface{}) {
+		cs, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data := decode(t, s, cs); !reflect.DeepEqual(data, expected) {
+			t.Fatalf("unexpected config %s", cs.Data)
+		}
+	}
+
+	ctx := context.TODO()
+	secrets := client.CoreV1().Secrets("default")
+	a := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "a", Namespace: "default", Labels: map[string]string{"app": "vine"}, ResourceVersion: "1"},
+		Data:       map[string][]byte{"user": []byte("vine")},
+	}
+	if _, err = secrets.Create(ctx, a, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	next(map[string]interface{}{"user": "vine"})
+
+	b := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "b", Namespace: "default", Labels: map[string]string{"app": "vine"}, ResourceVersion: "1"},
+		Data:       map[string][]byte{"password": []byte("b")},
+	}
+	if _, err = secrets.Create(ctx, b, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	next(map[string]interface{}{"user": "vine", "password": "b"})
+
+	a.ResourceVersion = "2"
+	a.Data["user"] = []byte("admin")
+	if _, err = secrets.Update(ctx, a, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	next(map[string]interface{}{"user": "admin", "password": "b"})
+
+	if err = secrets.Delete(ctx, "b", v1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	next(map[string]interface{}{"user": "admin"})
+
+	// a document which fails to decode is returned as error
+	a.ResourceVersion = "3"
+	a.Data["app.json"] = []byte("{")
+	if _, err = secrets.Update(ctx, a, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Next(); err == nil {
+		t.Fatal("expected decode error")
+	}
+
+	if err = w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Next(); err == nil {
+		t.Fatal("expected stopped watcher")
+	}
+}
@@ -0,0 +1,139 @@
+package secret
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vine-io/vine/lib/config/encoder"
+	"github.com/vine-io/vine/lib/config/encoder/hcl"
+	"github.com/vine-io/vine/lib/config/encoder/json"
+	"github.com/vine-io/vine/lib/config/encoder/toml"
+	"github.com/vine-io/vine/lib/config/encoder/yaml"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var encoders = map[string]encoder.Encoder{
+	"json": json.NewEncoder(),
+	"yaml": yaml.NewEncoder(),
+	"yml":  yaml.NewEncoder(),
+	"toml": toml.NewEncoder(),
+	"hcl":  hcl.NewEncoder(),
+}
+
+func getClient(configPath string) (*kubernetes.Clientset, error) {
+	var config *rest.Config
+	var err error
+
+	if configPath == "" {
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", configPath)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+// keyEncoder returns the encoder of the document in the key, which is given
+// by WithKeyFormat or the extension of the key
+func keyEncoder(key string, formats map[string]string) (encoder.Encoder, bool) {
+	f, ok := formats[key]
+	if !ok {
+		i := strings.LastIndex(key, ".")
+		if i == -1 {
+			return nil, false
+		}
+		f = key[i+1:]
+	}
+	e, ok := encoders[f]
+	return e, ok
+}
+
+// makeMap merges the secrets into one tree, the later secrets and keys take
+// precedence. A key holding a document in a known format is merged into the
+// tree, any other key is an entry of the tree.
+func makeMap(secrets []*v1.Secret, formats map[string]string, binary map[string]bool) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+
+	for _, s := range secrets {
+		kv := make(map[string][]byte, len(s.Data)+len(s.StringData))
+		for k, v := range s.Data {
+			kv[k] = v
+		}
+		// StringData is merged into Data by the server, it takes precedence
+		for k, v := range s.StringData {
+			kv[k] = []byte(v)
+		}
+
+		keys := make([]string, 0, len(kv))
+		for k := range kv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if e, ok := keyEncoder(k, formats); ok {
+				var doc map[string]interface{}
+				if err := e.Decode(kv[k], &doc); err != nil {
+					return nil, fmt.Errorf("error decoding %s/%s: %v", s.Name, k, err)
+				}
+				merge(data, doc)
+				continue
+			}
+			data[k] = value(kv[k], binary[k])
+		}
+	}
+
+	return data, nil
+}
+
+// value returns the secret value as string, the values of the keys of
+// WithBinaryKey are base64 encoded
+func value(b []byte, binary bool) string {
+	if binary {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return string(b)
+}
+
+// lastModified returns the time of the last change of the secrets
+func lastModified(secrets []*v1.Secret) time.Time {
+	var t time.Time
+	for _, s := range secrets {
+		if lm := s.CreationTimestamp.Time; lm.After(t) {
+			t = lm
+		}
+		for _, f := range s.ManagedFields {
+			if f.Time != nil && f.Time.After(t) {
+				t = f.Time.Time
+			}
+		}
+	}
+	return t
+}
+
+// merge merges src into dst, the maps of both are merged recursively
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sm, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dm, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dm = make(map[string]interface{})
+			dst[k] = dm
+		}
+		merge(dm, sm)
+	}
+}
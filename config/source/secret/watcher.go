@@ -0,0 +1,206 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vine-io/vine/lib/config/source"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+type watcher struct {
+	s        *secret
+	selector labels.Selector
+	st       cache.Store
+	ct       cache.Controller
+	ch       chan *source.ChangeSet
+	errs     chan error
+
+	// mu orders the changesets, none is sent before the store is synced
+	mu     sync.Mutex
+	synced bool
+
+	exit chan bool
+	stop chan struct{}
+}
+
+func newWatcher(s *secret) (source.Watcher, error) {
+	w := &watcher{
+		s:    s,
+		ch:   make(chan *source.ChangeSet),
+		errs: make(chan error),
+		exit: make(chan bool),
+		stop: make(chan struct{}),
+	}
+
+	list := func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.name).String()
+	}
+	if s.selector != "" {
+		selector, err := labels.Parse(s.selector)
+		if err != nil {
+			return nil, err
+		}
+		w.selector = selector
+		list = func(options *metav1.ListOptions) {
+			options.LabelSelector = s.selector
+		}
+	}
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list(&options)
+			return s.client.CoreV1().Secrets(s.namespace).List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			list(&options)
+			return s.client.CoreV1().Secrets(s.namespace).Watch(context.TODO(), options)
+		},
+	}
+	st, ct := cache.NewInformer(
+		lw,
+		&v1.Secret{},
+		time.Second*30,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    w.handleAdd,
+			UpdateFunc: w.handleUpdate,
+			DeleteFunc: w.handleDelete,
+		},
+	)
+
+	w.ct = ct
+	w.st = st
+
+	go ct.Run(w.stop)
+	go w.sync()
+
+	return w, nil
+}
+
+// match reports whether the secret is one of the source
+func (w *watcher) match(obj interface{}) bool {
+	s, ok := obj.(*v1.Secret)
+	if !ok {
+		return false
+	}
+	if w.selector != nil {
+		return w.selector.Matches(labels.Set(s.Labels))
+	}
+	return s.Name == w.s.name
+}
+
+func (w *watcher) handleAdd(obj interface{}) {
+	if w.match(obj) {
+		w.handle()
+	}
+}
+
+func (w *watcher) handleUpdate(oldObj, newObj interface{}) {
+	if !w.match(oldObj) && !w.match(newObj) {
+		return
+	}
+	// resyncs deliver the unchanged secret again
+	if o, n := oldObj.(*v1.Secret), newObj.(*v1.Secret); o.ResourceVersion == n.ResourceVersion {
+		return
+	}
+	w.handle()
+}
+
+func (w *watcher) handleDelete(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	if w.match(obj) {
+		w.handle()
+	}
+}
+
+// sync waits for the initial list of the secrets and sends them in one
+// changeset
+func (w *watcher) sync() {
+	if !cache.WaitForCacheSync(w.stop, w.ct.HasSynced) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.synced = true
+	if secrets := w.list(); len(secrets) > 0 {
+		w.send(secrets)
+	}
+}
+
+// list returns the secrets of the source in the store sorted by name
+func (w *watcher) list() []*v1.Secret {
+	var secrets []*v1.Secret
+	for _, obj := range w.st.List() {
+		if w.match(obj) {
+			secrets = append(secrets, obj.(*v1.Secret))
+		}
+	}
+	sortSecrets(secrets)
+	return secrets
+}
+
+// handle sends the changeset of the secrets in the store, the changes of the
+// initial list are sent by sync
+func (w *watcher) handle() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.synced {
+		w.send(w.list())
+	}
+}
+
+// send sends the changeset of the secrets, a secret which fails to decode
+// is returned by Next as error
+func (w *watcher) send(secrets []*v1.Secret) {
+	cs, err := w.s.changeSet(secrets)
+	if err != nil {
+		select {
+		case w.errs <- err:
+		case <-w.exit:
+		}
+		return
+	}
+	if len(secrets) == 0 {
+		cs.Timestamp = time.Now()
+	}
+
+	select {
+	case w.ch <- cs:
+	case <-w.exit:
+	}
+}
+
+// Next
+func (w *watcher) Next() (*source.ChangeSet, error) {
+	select {
+	case cs := <-w.ch:
+		return cs, nil
+	case err := <-w.errs:
+		return nil, err
+	case <-w.exit:
+		return nil, errors.New("watcher stopped")
+	}
+}
+
+// Stop
+func (w *watcher) Stop() error {
+	select {
+	case <-w.exit:
+		return nil
+	default:
+		close(w.exit)
+		close(w.stop)
+	}
+	return nil
+}
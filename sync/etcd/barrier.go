@@ -0,0 +1,301 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	gosync "sync"
+
+	"github.com/vine-io/vine/lib/sync"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	cc "go.etcd.io/etcd/client/v3/concurrency"
+	recipe "go.etcd.io/etcd/client/v3/experimental/recipes"
+)
+
+var (
+	// ErrBarrierHeld is returned by Hold when the barrier is already held
+	ErrBarrierHeld = errors.New("barrier held")
+	// ErrTooManyParticipants is returned by Enter when the double barrier is full
+	ErrTooManyParticipants = recipe.ErrTooManyClients
+)
+
+// Barrier blocks its waiters until it is released. The barrier is kept by the
+// session of its holder, it is released when the holder dies.
+//
+// recipe.Barrier can't be used here: its Hold writes the key without a lease,
+// so a dead holder would block the waiters forever, and its Wait only returns
+// after it observed the put of the key, so it never returns once the key was
+// written before the revision it reads. Wait uses the watch of the recipes.
+type Barrier struct {
+	e   *EtcdSync
+	key string
+
+	mu gosync.Mutex
+	s  *cc.Session
+}
+
+// NewBarrier returns the Barrier of the id
+func (e *EtcdSync) NewBarrier(id string) *Barrier {
+	return &Barrier{e: e, key: e.queueKey("barrier", id)}
+}
+
+// Hold raises the barrier, it returns ErrBarrierHeld when it is already held
+func (b *Barrier) Hold(ctx context.Context, opts ...LockOption) error {
+	var options LockOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	s, err := b.e.session(options)
+	if err != nil {
+		return err
+	}
+
+	cmp := clientv3.Compare(clientv3.CreateRevision(b.key), "=", 0)
+	put := clientv3.OpPut(b.key, "", clientv3.WithLease(s.Lease()))
+	rsp, err := b.e.client.Txn(ctx).If(cmp).Then(put).Commit()
+	if err == nil && !rsp.Succeeded {
+		err = ErrBarrierHeld
+	}
+	if err != nil {
+		_ = s.Close()
+		return err
+	}
+
+	b.mu.Lock()
+	b.s = s
+	b.mu.Unlock()
+	return nil
+}
+
+// Release lowers the barrier held by Hold, the waiters are unblocked
+func (b *Barrier) Release(ctx context.Context) error {
+	b.mu.Lock()
+	s := b.s
+	b.s = nil
+	b.mu.Unlock()
+	if s == nil {
+		return ErrLockReleased
+	}
+
+	defer s.Close()
+	_, err := b.e.client.Delete(ctx, b.key)
+	return err
+}
+
+// Wait blocks until the barrier is released
+func (b *Barrier) Wait(ctx context.Context, opts ...LockOption) error {
+	var options LockOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.Wait != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Wait)
+		defer cancel()
+	}
+
+	rsp, err := b.e.client.Get(ctx, b.key)
+	if err != nil {
+		return err
+	}
+	if len(rsp.Kvs) == 0 {
+		return nil
+	}
+
+	rc := newRecipeClient(b.e.client)
+	rc.use(ctx)
+	_, err = recipe.WaitEvents(rc.client, b.key, rsp.Header.Revision+1, []mvccpb.Event_EventType{mvccpb.DELETE})
+	if ctx.Err() != nil {
+		return sync.ErrLockTimeout
+	}
+	return err
+}
+
+// DoubleBarrier blocks its participants on Enter until count participants
+// entered, and on Leave until all of them left. It wraps recipe.DoubleBarrier,
+// the participants are kept by their sessions and a participant which dies is
+// removed from the barrier.
+type DoubleBarrier struct {
+	e     *EtcdSync
+	pfx   string
+	count int
+
+	mu gosync.Mutex
+	rc *recipeClient
+	s  *cc.Session
+	b  *recipe.DoubleBarrier
+}
+
+// NewDoubleBarrier returns the DoubleBarrier of the id for count participants
+func (e *EtcdSync) NewDoubleBarrier(id string, count int) *DoubleBarrier {
+	return &DoubleBarrier{e: e, pfx: e.queueKey("double-barrier", id), count: count}
+}
+
+// Enter joins the barrier and blocks until count participants joined
+func (b *DoubleBarrier) Enter(ctx context.Context, opts ...LockOption) error {
+	var options LockOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.Wait != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Wait)
+		defer cancel()
+	}
+
+	rc := newRecipeClient(b.e.client)
+	s, err := newSession(rc.client, options)
+	if err != nil {
+		return err
+	}
+
+	// the wait ends when the session is lost
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	db := recipe.NewDoubleBarrier(s, b.pfx, b.count)
+	rc.use(ctx)
+	err = db.Enter()
+	if err == nil && ctx.Err() == nil {
+		b.mu.Lock()
+		b.rc, b.s, b.b = rc, s, db
+		b.mu.Unlock()
+		return nil
+	}
+
+	lost := false
+	select {
+	case <-s.Done():
+		lost = true
+	default:
+	}
+	// the key of the participant is removed with its session
+	_ = s.Close()
+	switch {
+	case lost:
+		return ErrLockLost
+	case ctx.Err() != nil:
+		return sync.ErrLockTimeout
+	}
+	return err
+}
+
+// Leave leaves the barrier and blocks until all participants left
+func (b *DoubleBarrier) Leave(ctx context.Context, opts ...LockOption) error {
+	var options LockOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.Wait != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Wait)
+		defer cancel()
+	}
+
+	b.mu.Lock()
+	rc, s, db := b.rc, b.s, b.b
+	b.mu.Unlock()
+	if s == nil {
+		return ErrLockReleased
+	}
+
+	rc.use(ctx)
+	err := db.Leave()
+	if ctx.Err() != nil {
+		return sync.ErrLockTimeout
+	}
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.rc, b.s, b.b = nil, nil, nil
+	b.mu.Unlock()
+	return s.Close()
+}
+
+// recipeClient hands the recipes a client whose requests and watches run with
+// the context of the current call, the recipes have no context of their own.
+type recipeClient struct {
+	client *clientv3.Client
+
+	mu  gosync.Mutex
+	ctx context.Context
+}
+
+func newRecipeClient(c *clientv3.Client) *recipeClient {
+	rc := &recipeClient{ctx: context.Background()}
+	rc.client = clientv3.NewCtxClient(context.Background())
+	rc.client.KV = &recipeKV{KV: c.KV, rc: rc}
+	rc.client.Watcher = &recipeWatcher{Watcher: c.Watcher, rc: rc}
+	rc.client.Lease = c.Lease
+	return rc
+}
+
+// use runs the following requests of the recipes with the context
+func (rc *recipeClient) use(ctx context.Context) {
+	rc.mu.Lock()
+	rc.ctx = ctx
+	rc.mu.Unlock()
+}
+
+func (rc *recipeClient) context() context.Context {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.ctx
+}
+
+type recipeKV struct {
+	clientv3.KV
+	rc *recipeClient
+}
+
+func (kv *recipeKV) Put(_ context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	return kv.KV.Put(kv.rc.context(), key, val, opts...)
+}
+
+func (kv *recipeKV) Get(_ context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	return kv.KV.Get(kv.rc.context(), key, opts...)
+}
+
+func (kv *recipeKV) Delete(_ context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	return kv.KV.Delete(kv.rc.context(), key, opts...)
+}
+
+func (kv *recipeKV) Do(_ context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	return kv.KV.Do(kv.rc.context(), op)
+}
+
+func (kv *recipeKV) Txn(_ context.Context) clientv3.Txn {
+	return kv.KV.Txn(kv.rc.context())
+}
+
+type recipeWatcher struct {
+	clientv3.Watcher
+	rc *recipeClient
+}
+
+// Watch ends the watch when the recipe or the current call is done
+func (w *recipeWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	cctx := w.rc.context()
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-cctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return w.Watcher.Watch(ctx, key, opts...)
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"path"
 	"strings"
 	gosync "sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/vine-io/vine/lib/sync"
@@ -20,12 +22,7 @@ type EtcdSync struct {
 	client  *clientv3.Client
 
 	mtx   gosync.Mutex
-	locks map[string]*etcdLock
-}
-
-type etcdLock struct {
-	s *cc.Session
-	m *cc.Mutex
+	locks map[string]*Lock
 }
 
 func configure(e *EtcdSync, client *clientv3.Client, opts ...sync.Option) error {
@@ -96,30 +93,13 @@ func (e *EtcdSync) Leader(ctx context.Context, name string, opts ...sync.LeaderO
 		options.Namespace = "default"
 	}
 
-	// make path
-	cpath := path.Join(e.prefix, "leaders", options.Namespace)
-
-	s, err := cc.NewSession(e.client)
-	if err != nil {
-		return nil, err
-	}
-
-	l := cc.NewElection(s, cpath)
+	cpath := path.Join(e.prefix, "leaders", options.Namespace, name)
 
-	leader := &etcdLeader{
-		opts:    options,
-		s:       s,
-		e:       l,
-		prefix:  e.prefix,
-		name:    name,
-		elected: make(chan struct{}, 1),
-		stop:    make(chan struct{}, 1),
-	}
-	go leader.campaign()
-
-	return leader, nil
+	return newEtcdLeader(ctx, e.client, cpath, name, options)
 }
 
+// ListMembers returns the members of the elections in the namespace, the
+// oldest member of each election is its primary.
 func (e *EtcdSync) ListMembers(ctx context.Context, opts ...sync.ListMembersOption) ([]*sync.Member, error) {
 	var options sync.ListMembersOptions
 	for _, opt := range opts {
@@ -132,19 +112,21 @@ func (e *EtcdSync) ListMembers(ctx context.Context, opts ...sync.ListMembersOpti
 
 	members := make([]*sync.Member, 0)
 
-	key := path.Join(e.prefix, "leaders", options.Namespace)
-	rsp, err := e.client.Get(ctx, key, clientv3.WithPrefix())
+	key := path.Join(e.prefix, "leaders", options.Namespace) + "/"
+	rsp, err := e.client.Get(ctx, key, clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend))
 	if err != nil {
 		return nil, err
 	}
 
-	p, _ := e.client.Get(ctx, path.Join(e.prefix, "primary", options.Namespace))
-
+	elected := map[string]bool{}
 	for _, kv := range rsp.Kvs {
-		val := &sync.Member{}
-		err = json.Unmarshal(kv.Value, &val)
-		if err == nil {
-			if p != nil && val.Id == string(p.Kvs[0].Value) {
+		v := &memberValue{}
+		err = json.Unmarshal(kv.Value, v)
+		if err == nil && !v.Resigned {
+			val := &v.Member
+			if !elected[val.Leader] {
+				elected[val.Leader] = true
 				val.Role = sync.Primary
 			} else {
 				val.Role = sync.Follow
@@ -157,7 +139,11 @@ func (e *EtcdSync) ListMembers(ctx context.Context, opts ...sync.ListMembersOpti
 }
 
 func (e *EtcdSync) WatchElect(ctx context.Context, opts ...sync.WatchElectOption) (sync.ElectWatcher, error) {
-	return newEtcdWatcher(e, opts...)
+	w, err := newEtcdWatcher(ctx, e, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
 }
 
 func (e *EtcdSync) Lock(ctx context.Context, id string, opts ...sync.LockOption) error {
@@ -166,8 +152,41 @@ func (e *EtcdSync) Lock(ctx context.Context, id string, opts ...sync.LockOption)
 		o(&options)
 	}
 
-	// make path
-	key := path.Join(e.prefix, strings.Replace(e.options.Prefix+id, "/", "-", -1))
+	_, err := e.Acquire(ctx, id, LockTTL(options.TTL), LockWait(options.Wait))
+	return err
+}
+
+// Acquire acquires the lock and returns its handle. A second acquisition of the
+// lock blocks until it is released, unless the lock is reentrant and acquired
+// by the same owner.
+func (e *EtcdSync) Acquire(ctx context.Context, id string, opts ...LockOption) (*Lock, error) {
+	return e.acquire(ctx, id, false, opts...)
+}
+
+// TryAcquire acquires the lock without waiting, it returns ErrLocked when
+// the lock is held by another owner.
+func (e *EtcdSync) TryAcquire(ctx context.Context, id string, opts ...LockOption) (*Lock, error) {
+	return e.acquire(ctx, id, true, opts...)
+}
+
+func (e *EtcdSync) acquire(ctx context.Context, id string, try bool, opts ...LockOption) (*Lock, error) {
+	var options LockOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.Owner == "" {
+		options.Owner = uuid.New().String()
+	}
+
+	if options.Reentrant {
+		e.mtx.Lock()
+		l, ok := e.locks[id]
+		e.mtx.Unlock()
+		if ok && l.reenter(options.Owner) {
+			return l, nil
+		}
+	}
 
 	var sopts []cc.SessionOption
 	if options.TTL > 0 {
@@ -176,51 +195,111 @@ func (e *EtcdSync) Lock(ctx context.Context, id string, opts ...sync.LockOption)
 
 	s, err := cc.NewSession(e.client, sopts...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	m := cc.NewMutex(s, key)
-
 	if options.Wait != 0 {
-		ctx, _ = context.WithTimeout(ctx, options.Wait)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Wait)
+		defer cancel()
 	}
 
-	ech := make(chan error, 1)
-	go func() {
-		ech <- m.Lock(ctx)
-	}()
-
-	select {
-	case <-ctx.Done():
-		err = sync.ErrLockTimeout
-	case err = <-ech:
+	// register as waiter under the key of the concurrency.Mutex, so that the
+	// lock is described by its owner while waiting
+	info := &LockInfo{
+		Owner:     options.Owner,
+		Metadata:  options.Metadata,
+		Requested: time.Now(),
+	}
+	pfx := e.lockKey(id)
+	key := fmt.Sprintf("%s/%x", pfx, s.Lease())
+	val, _ := json.Marshal(info)
+	rsp, err := e.client.Put(ctx, key, string(val), clientv3.WithLease(s.Lease()))
+	if err != nil {
+		_ = s.Close()
+		return nil, err
 	}
 
+	m := cc.NewMutex(s, pfx)
+	if try {
+		err = m.TryLock(ctx)
+	} else {
+		err = m.Lock(ctx)
+	}
 	if err != nil {
+		_, _ = e.client.Delete(context.Background(), key)
 		_ = s.Close()
-		return err
+		if err == cc.ErrLocked {
+			return nil, ErrLocked
+		}
+		if ctx.Err() != nil {
+			return nil, sync.ErrLockTimeout
+		}
+		return nil, err
 	}
 
-	e.mtx.Lock()
-	e.locks[id] = &etcdLock{
-		s: s,
-		m: m,
+	info.Acquired = time.Now()
+	val, _ = json.Marshal(info)
+	_, _ = e.client.Txn(ctx).If(m.IsOwner()).Then(clientv3.OpPut(key, string(val), clientv3.WithLease(s.Lease()))).Commit()
+
+	l := &Lock{
+		e:     e,
+		id:    id,
+		owner: options.Owner,
+		s:     s,
+		m:     m,
+		token: rsp.Header.Revision,
+		count: 1,
 	}
+
+	e.mtx.Lock()
+	e.locks[id] = l
 	e.mtx.Unlock()
-	return nil
+	return l, nil
+}
+
+// Inspect returns the holder and the waiters of the lock
+func (e *EtcdSync) Inspect(ctx context.Context, id string) (*LockState, error) {
+	rsp, err := e.client.Get(ctx, e.lockKey(id)+"/", clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	state := &LockState{Id: id, Waiters: make([]*LockInfo, 0)}
+	for i, kv := range rsp.Kvs {
+		info := &LockInfo{}
+		// keys of other lockers carry no description
+		_ = json.Unmarshal(kv.Value, info)
+		info.Key = string(kv.Key)
+		info.Lease = kv.Lease
+		info.Revision = kv.CreateRevision
+
+		if i == 0 {
+			state.Holder = info
+		} else {
+			info.Acquired = time.Time{}
+			state.Waiters = append(state.Waiters, info)
+		}
+	}
+
+	return state, nil
 }
 
+// Unlock releases the lock acquired by Lock in this process
 func (e *EtcdSync) Unlock(ctx context.Context, id string) error {
 	e.mtx.Lock()
-	defer e.mtx.Unlock()
-	v, ok := e.locks[id]
+	l, ok := e.locks[id]
+	e.mtx.Unlock()
 	if !ok {
 		return errors.New("lock not found")
 	}
-	defer v.s.Close()
-	err := v.m.Unlock(context.Background())
-	delete(e.locks, id)
-	return err
+	return l.Unlock(ctx)
+}
+
+// lockKey returns the etcd prefix of the lock
+func (e *EtcdSync) lockKey(id string) string {
+	return path.Join(e.prefix, strings.Replace(e.options.Prefix+id, "/", "-", -1))
 }
 
 func (e *EtcdSync) String() string {
@@ -240,7 +319,7 @@ func NewSync(opts ...sync.Option) sync.Sync {
 	e := &EtcdSync{
 		prefix:  options.Prefix,
 		options: options,
-		locks:   make(map[string]*etcdLock),
+		locks:   make(map[string]*Lock),
 	}
 	return e
 }
@@ -255,7 +334,7 @@ func NewEtcdSync(client *clientv3.Client, opts ...sync.Option) sync.Sync {
 		prefix:  options.Prefix,
 		options: options,
 		client:  client,
-		locks:   make(map[string]*etcdLock),
+		locks:   make(map[string]*Lock),
 	}
 	return e
 }
@@ -3,6 +3,8 @@ package etcd
 import (
 	"context"
 	"encoding/json"
+	gosync "sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -24,9 +26,9 @@ func Test_etcdSync_Leader(t *testing.T) {
 	}
 
 	go func() {
-		_, err = s.Leader(ctx, id, sync.LeaderTTL(20))
+		_, err := s.Leader(ctx, id, sync.LeaderTTL(20))
 		if err != nil {
-			t.Fatalf("leader: %v", err)
+			t.Errorf("leader: %v", err)
 		}
 	}()
 
@@ -168,7 +170,10 @@ func TestEtcdLeader_Watch(t *testing.T) {
 	defer watcher.Close()
 	go func() {
 		for {
-			m, _ := watcher.Next()
+			m, err := watcher.Next()
+			if err != nil {
+				return
+			}
 			t.Log(m)
 		}
 	}()
@@ -212,3 +217,539 @@ func Test_etcdSync_Lock(t *testing.T) {
 		t.Fatalf("unlock: %v", err)
 	}
 }
+
+func Test_etcdSync_Acquire(t *testing.T) {
+	s := NewSync()
+	err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := s.(*EtcdSync)
+
+	ctx := context.TODO()
+	lock := "lock24"
+	l, err := e.Acquire(ctx, lock, LockOwner("owner"), LockReentrant())
+	if err != nil {
+		t.Fatalf("lock %s: %v", lock, err)
+	}
+
+	l2, err := e.Acquire(ctx, lock, LockOwner("owner"), LockReentrant())
+	if err != nil || l2 != l {
+		t.Fatalf("reentrant lock %s: %v", lock, err)
+	}
+
+	_, err = e.Acquire(ctx, lock, LockOwner("other"), LockWait(time.Second*2))
+	if err != sync.ErrLockTimeout {
+		t.Fatalf("lock locked: %v", err)
+	}
+
+	if err = l.Refresh(ctx); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	_ = l.Unlock(ctx)
+	select {
+	case <-l.Done():
+		t.Fatal("lock released by reentrant unlock")
+	default:
+	}
+
+	if err = l2.Unlock(ctx); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	<-l.Done()
+
+	if err = l.Refresh(ctx); err != ErrLockReleased {
+		t.Fatalf("refresh released lock: %v", err)
+	}
+}
+
+func Test_etcdSync_TryAcquire(t *testing.T) {
+	s := NewSync()
+	err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := s.(*EtcdSync)
+
+	ctx := context.TODO()
+	lock := "lock25"
+	l, err := e.TryAcquire(ctx, lock, LockOwner("owner"), LockMetadata(map[string]string{"host": "a"}))
+	if err != nil {
+		t.Fatalf("lock %s: %v", lock, err)
+	}
+	defer l.Unlock(ctx)
+
+	if _, err = e.TryAcquire(ctx, lock); err != ErrLocked {
+		t.Fatalf("try lock locked: %v", err)
+	}
+
+	state, err := e.Inspect(ctx, lock)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if state.Holder == nil || state.Holder.Owner != "owner" || state.Holder.Metadata["host"] != "a" {
+		t.Fatalf("unexpected holder %+v", state.Holder)
+	}
+	if state.Holder.Revision != l.Token() {
+		t.Fatalf("expected token %d, got %d", state.Holder.Revision, l.Token())
+	}
+	if len(state.Waiters) != 0 {
+		t.Fatalf("unexpected waiters %v", state.Waiters)
+	}
+}
+
+func Test_etcdSync_RWMutex(t *testing.T) {
+	s := NewSync()
+	err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := s.(*EtcdSync).NewRWMutex("rwmutex")
+
+	ctx := context.TODO()
+	r1, err := rw.RLock(ctx)
+	if err != nil {
+		t.Fatalf("read lock: %v", err)
+	}
+	r2, err := rw.RLock(ctx, LockWait(time.Second))
+	if err != nil {
+		t.Fatalf("shared read lock: %v", err)
+	}
+
+	if _, err = rw.Lock(ctx, LockWait(time.Second)); err != sync.ErrLockTimeout {
+		t.Fatalf("write lock while reading: %v", err)
+	}
+
+	_ = r1.Release(ctx)
+	_ = r2.Release(ctx)
+
+	w, err := rw.Lock(ctx, LockWait(time.Second))
+	if err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+	_ = w.Release(ctx)
+}
+
+func Test_etcdSync_Semaphore(t *testing.T) {
+	s := NewSync()
+	err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sem := s.(*EtcdSync).NewSemaphore("semaphore", 2)
+
+	ctx := context.TODO()
+	p1, err := sem.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	p2, err := sem.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer p2.Release(ctx)
+
+	if _, err = sem.Acquire(ctx, LockWait(time.Second)); err != sync.ErrLockTimeout {
+		t.Fatalf("acquire exhausted semaphore: %v", err)
+	}
+
+	_ = p1.Release(ctx)
+	p3, err := sem.Acquire(ctx, LockWait(time.Second))
+	if err != nil {
+		t.Fatalf("acquire released permit: %v", err)
+	}
+	_ = p3.Release(ctx)
+}
+
+func TestEtcdLeader_Campaign(t *testing.T) {
+	s := NewSync()
+	err := s.Init()
+	if err != nil {
+		t.Fatalf("sync init: %v", err)
+	}
+
+	ctx := context.TODO()
+	l, err := s.Leader(ctx, "campaign", sync.LeaderNS("campaign"), sync.LeaderTTL(5))
+	if err != nil {
+		t.Fatalf("leader: %v", err)
+	}
+	leader := l.(Leader)
+
+	elected := make(chan struct{}, 2)
+	demoted := make(chan struct{}, 2)
+	leader.OnElected(func() { elected <- struct{}{} })
+	leader.OnDemoted(func() { demoted <- struct{}{} })
+
+	<-elected
+	if !leader.IsLeader() {
+		t.Fatal("expected leadership")
+	}
+	token := leader.Token()
+
+	// lose the session, the member campaigns again
+	el := l.(*etcdLeader)
+	el.mu.Lock()
+	lease := el.s.Lease()
+	el.mu.Unlock()
+	if _, err = s.(*EtcdSync).client.Revoke(ctx, lease); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	select {
+	case <-demoted:
+	case <-time.After(time.Second * 10):
+		t.Fatal("not demoted after session loss")
+	}
+	select {
+	case <-elected:
+	case <-time.After(time.Second * 10):
+		t.Fatal("not elected again")
+	}
+	if leader.Token() <= token {
+		t.Fatalf("expected a newer token than %d, got %d", token, leader.Token())
+	}
+
+	if err = leader.Resign(); err != nil {
+		t.Fatalf("resign: %v", err)
+	}
+	<-demoted
+	<-leader.Done()
+
+	members, err := s.ListMembers(ctx, sync.MemberNS("campaign"))
+	if err != nil {
+		t.Fatalf("list members: %v", err)
+	}
+	if len(members) != 0 {
+		t.Fatalf("unexpected members %v", members)
+	}
+}
+
+func TestFence_Check(t *testing.T) {
+	f := NewFence()
+	ctx := WithToken(context.TODO(), 5)
+	if err := f.CheckContext(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Check("a", 4); err != ErrStaleToken {
+		t.Fatalf("expected stale token, got %v", err)
+	}
+	if err := f.Check("b", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.CheckContext(context.TODO(), "a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEtcdWatcher_NextEvent(t *testing.T) {
+	s := NewSync()
+	err := s.Init()
+	if err != nil {
+		t.Fatalf("sync init: %v", err)
+	}
+
+	ctx := context.TODO()
+	ns := "watchevents"
+	w, err := s.WatchElect(ctx, sync.WatchNS(ns))
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer w.Close()
+	watcher := w.(ElectWatcher)
+
+	l1, err := s.Leader(ctx, "events", sync.LeaderNS(ns), sync.LeaderId("1"), sync.LeaderTTL(5))
+	if err != nil {
+		t.Fatalf("leader: %v", err)
+	}
+	l2, err := s.Leader(ctx, "events", sync.LeaderNS(ns), sync.LeaderId("2"), sync.LeaderTTL(5))
+	if err != nil {
+		t.Fatalf("leader: %v", err)
+	}
+	<-l1.Status()
+
+	next := func(typ EventType, id string) {
+		ev, err := watcher.NextEvent()
+		if err != nil {
+			t.Fatalf("next event: %v", err)
+		}
+		if ev.Type != typ || ev.Member.Id != id {
+			t.Fatalf("expected %s of %s, got %s of %s", typ, id, ev.Type, ev.Member.Id)
+		}
+	}
+
+	next(EventJoined, "1")
+	next(EventElected, "1")
+	next(EventJoined, "2")
+
+	if err = l1.Resign(); err != nil {
+		t.Fatalf("resign: %v", err)
+	}
+	next(EventResigned, "1")
+	next(EventElected, "2")
+
+	// lose the session of the leader
+	el := l2.(*etcdLeader)
+	el.mu.Lock()
+	lease := el.s.Lease()
+	el.mu.Unlock()
+	if _, err = s.(*EtcdSync).client.Revoke(ctx, lease); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	next(EventExpired, "2")
+	next(EventJoined, "2")
+	next(EventElected, "2")
+
+	if err = l2.Resign(); err != nil {
+		t.Fatalf("resign: %v", err)
+	}
+	next(EventResigned, "2")
+
+	w.Close()
+	if _, err = watcher.NextEvent(); err != ErrWatcherClosed {
+		t.Fatalf("expected closed watcher, got %v", err)
+	}
+}
+
+func Test_etcdSync_Barrier(t *testing.T) {
+	s := NewSync()
+	err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := s.(*EtcdSync).NewBarrier("barrier")
+
+	ctx := context.TODO()
+	if err = b.Hold(ctx, LockTTL(time.Second*5)); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	if err = b.Hold(ctx); err != ErrBarrierHeld {
+		t.Fatalf("hold held barrier: %v", err)
+	}
+	// later writes don't hide the barrier from the waiters
+	if _, err = s.(*EtcdSync).client.Put(ctx, "barrier-other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Wait(ctx, LockWait(time.Millisecond*200)); err != sync.ErrLockTimeout {
+		t.Fatalf("wait held barrier: %v", err)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		_ = b.Release(ctx)
+	}()
+	if err = b.Wait(ctx, LockWait(time.Second*5)); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+}
+
+func Test_etcdSync_DoubleBarrier(t *testing.T) {
+	s := NewSync()
+	err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := s.(*EtcdSync)
+
+	ctx := context.TODO()
+	n := 3
+	var entered, left int32
+	var wg gosync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := e.NewDoubleBarrier("double", n)
+			if err := b.Enter(ctx, LockTTL(time.Second*5), LockWait(time.Second*5)); err != nil {
+				t.Errorf("enter: %v", err)
+				return
+			}
+			if v := atomic.AddInt32(&entered, 1); v > int32(n) {
+				t.Errorf("too many entered: %d", v)
+			}
+			if err := b.Leave(ctx, LockWait(time.Second*5)); err != nil {
+				t.Errorf("leave: %v", err)
+				return
+			}
+			if atomic.LoadInt32(&entered) != int32(n) {
+				t.Errorf("left before all entered")
+			}
+			atomic.AddInt32(&left, 1)
+		}()
+	}
+	wg.Wait()
+
+	if left != int32(n) {
+		t.Fatalf("expected %d participants left, got %d", n, left)
+	}
+
+	// a single participant can't pass the barrier
+	b := e.NewDoubleBarrier("double", n)
+	if err = b.Enter(ctx, LockWait(time.Millisecond*200)); err != sync.ErrLockTimeout {
+		t.Fatalf("enter: %v", err)
+	}
+}
+
+func Test_etcdSync_Coordinator(t *testing.T) {
+	s := NewSync()
+	err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := s.(*EtcdSync)
+
+	ctx := context.TODO()
+	shards := 8
+	next := func(c *Coordinator) *Assignment {
+		select {
+		case a := <-c.Assignments():
+			return a
+		case <-time.After(time.Second * 5):
+			t.Fatalf("no assignment of %s", c.Id())
+		}
+		return nil
+	}
+	// until returns the first assignment with n shards, shards are taken over
+	// one by one
+	until := func(c *Coordinator, n int) *Assignment {
+		for {
+			if a := next(c); len(a.Shards) == n {
+				return a
+			}
+		}
+	}
+
+	c1, err := e.NewCoordinator(ctx, "group", shards, ShardId("1"), ShardTTL(time.Second*5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	if a := next(c1); len(a.Shards) != shards {
+		t.Fatalf("expected all shards, got %v", a.Shards)
+	}
+
+	c2, err := e.NewCoordinator(ctx, "group", shards, ShardId("2"), ShardTTL(time.Second*5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second member waits until the first released its shards
+	a1 := next(c1)
+	if len(a1.Revoked) == 0 || len(a1.Shards)+len(a1.Revoked) != shards {
+		t.Fatalf("unexpected assignment %+v", a1)
+	}
+	select {
+	case a := <-c2.Assignments():
+		if len(a.Shards) != 0 {
+			t.Fatalf("shards taken before the handoff: %v", a.Shards)
+		}
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	a1.Release()
+	a2 := until(c2, len(a1.Revoked))
+	if a := next(c1); len(a.Revoked) != 0 || len(a.Shards)+len(a2.Shards) != shards {
+		t.Fatalf("unexpected assignment %+v", a)
+	}
+
+	// the shards of a leaving member are taken over
+	if err = c2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	until(c1, shards)
+}
+
+func TestConsistentHash(t *testing.T) {
+	members := []string{"a", "b", "c"}
+	owners := ConsistentHash(0).Assign(64, members)
+	counts := map[string]int{}
+	for _, owner := range owners {
+		counts[owner]++
+	}
+	if len(counts) != len(members) {
+		t.Fatalf("unbalanced assignment %v", counts)
+	}
+
+	// only the shards of the leaving member move
+	next := ConsistentHash(0).Assign(64, members[:2])
+	for shard, owner := range owners {
+		if owner != "c" && next[shard] != owner {
+			t.Fatalf("shard %d moved from %s to %s", shard, owner, next[shard])
+		}
+	}
+}
+
+func TestEtcdLeader_Status(t *testing.T) {
+	e := &etcdLeader{done: make(chan struct{})}
+	status := e.Status()
+
+	// the demotion is not lost when the election was not read
+	e.setElected(true)
+	e.setElected(false)
+	if <-status {
+		t.Fatal("expected demotion")
+	}
+}
+
+func TestEtcdLeader_OnElected(t *testing.T) {
+	s := NewSync()
+	err := s.Init()
+	if err != nil {
+		t.Fatalf("sync init: %v", err)
+	}
+
+	ctx := context.TODO()
+	l, err := s.Leader(ctx, "on-elected", sync.LeaderNS("on-elected"), sync.LeaderTTL(5))
+	if err != nil {
+		t.Fatalf("leader: %v", err)
+	}
+	leader := l.(Leader)
+
+	// the callbacks are registered before, during and after the election
+	var mu gosync.Mutex
+	calls := make([]int, 3)
+	elected := make(chan int, 8)
+	register := func(i int) {
+		leader.OnElected(func() {
+			mu.Lock()
+			calls[i]++
+			mu.Unlock()
+			elected <- i
+		})
+	}
+	register(0)
+	go register(1)
+	for i := 0; i < 2; i++ {
+		<-elected
+	}
+	register(2)
+	<-elected
+
+	// lose the session, each callback is called once for the new term
+	el := l.(*etcdLeader)
+	el.mu.Lock()
+	lease := el.s.Lease()
+	el.mu.Unlock()
+	if _, err = s.(*EtcdSync).client.Revoke(ctx, lease); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-elected:
+		case <-time.After(time.Second * 10):
+			t.Fatal("not elected again")
+		}
+	}
+
+	if err = leader.Resign(); err != nil {
+		t.Fatalf("resign: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, n := range calls {
+		if n != 2 {
+			t.Fatalf("callback %d called %d times in 2 terms", i, n)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	gosync "sync"
+
+	"github.com/vine-io/vine/util/context/metadata"
+)
+
+// TokenHeader is the metadata key carrying the fencing token of a request
+const TokenHeader = "Fencing-Token"
+
+// ErrStaleToken is returned when a request carries an older fencing token
+// than a request accepted before
+var ErrStaleToken = errors.New("stale fencing token")
+
+// WithToken attaches the fencing token to the metadata of the context, so that
+// it is sent along with the outgoing requests and fences the writes of the
+// cache/etcd plugin.
+func WithToken(ctx context.Context, token int64) context.Context {
+	return metadata.Set(ctx, TokenHeader, strconv.FormatInt(token, 10))
+}
+
+// TokenFromContext returns the fencing token attached to the context
+func TokenFromContext(ctx context.Context) (int64, bool) {
+	v, ok := metadata.Get(ctx, TokenHeader)
+	if !ok {
+		return 0, false
+	}
+	token, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return token, true
+}
+
+// Fence rejects the requests of former holders of a lock or leadership, it
+// remembers the highest token accepted for every resource.
+type Fence struct {
+	mu     gosync.Mutex
+	tokens map[string]int64
+}
+
+// NewFence returns an empty Fence
+func NewFence() *Fence {
+	return &Fence{tokens: make(map[string]int64)}
+}
+
+// Check accepts the token if it isn't older than the tokens accepted for
+// the resource before, otherwise it returns ErrStaleToken.
+func (f *Fence) Check(resource string, token int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if token < f.tokens[resource] {
+		return ErrStaleToken
+	}
+	f.tokens[resource] = token
+	return nil
+}
+
+// CheckContext checks the token attached to the context, requests without
+// a token are accepted.
+func (f *Fence) CheckContext(ctx context.Context, resource string) error {
+	token, ok := TokenFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return f.Check(resource, token)
+}
@@ -3,128 +3,417 @@ package etcd
 import (
 	"context"
 	"encoding/json"
-	"path"
+	"fmt"
+	gosync "sync"
+	"time"
 
 	"github.com/vine-io/vine/lib/sync"
+	clientv3 "go.etcd.io/etcd/client/v3"
 	cc "go.etcd.io/etcd/client/v3/concurrency"
 )
 
+// campaignRetry is the delay before campaigning again after a failure
+var campaignRetry = time.Second
+
 type Value struct {
 	Namespace string `json:"namespace"`
 	Id        string `json:"id"`
 }
 
+// memberValue is the value of the key of a member, a member marks itself as
+// resigned before leaving so that watchers can tell resignations from expiry.
+type memberValue struct {
+	sync.Member
+	Resigned bool `json:"resigned,omitempty"`
+}
+
+// Leader is the etcd implementation of sync.Leader. The member campaigns until
+// it resigns, and campaigns again whenever its session is lost.
+type Leader interface {
+	sync.Leader
+	// IsLeader reports whether the member holds the leadership
+	IsLeader() bool
+	// OnElected registers fn to be called whenever the member is elected
+	OnElected(fn func())
+	// OnDemoted registers fn to be called whenever the member loses the leadership
+	OnDemoted(fn func())
+	// Token returns the fencing token of the current term, it is zero when the
+	// member isn't the leader. The tokens of later terms are greater.
+	Token() int64
+	// Done is closed when the member left the election
+	Done() <-chan struct{}
+}
+
 type etcdLeader struct {
 	opts   sync.LeaderOptions
-	s      *cc.Session
-	e      *cc.Election
-	prefix string
+	client *clientv3.Client
+	pfx    string
 	name   string
 
-	err     error
-	elected chan struct{}
-	stop    chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu      gosync.Mutex
+	s       *cc.Session
+	e       *cc.Election
+	elected bool
+	// term counts the elections of the member
+	term      uint64
+	token     int64
+	err       error
+	onElected []*electedFunc
+	onDemoted []func()
+	status    []chan bool
+}
+
+// electedFunc is a callback of OnElected, it is called once per term
+type electedFunc struct {
+	fn   func()
+	term uint64
+}
+
+// report returns whether the term wasn't reported to the callback yet and
+// marks it as reported, e.mu is held
+func (f *electedFunc) report(term uint64) bool {
+	if f.term >= term {
+		return false
+	}
+	f.term = term
+	return true
+}
+
+func newEtcdLeader(ctx context.Context, client *clientv3.Client, pfx, name string, opts sync.LeaderOptions) (*etcdLeader, error) {
+	s, err := cc.NewSession(client, cc.WithTTL(int(opts.TTL)))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &etcdLeader{
+		opts:   opts,
+		client: client,
+		pfx:    pfx,
+		name:   name,
+		done:   make(chan struct{}),
+		s:      s,
+		e:      cc.NewElection(s, pfx),
+	}
+	l.ctx, l.cancel = context.WithCancel(ctx)
+
+	go l.run()
+
+	return l, nil
 }
 
 func (e *etcdLeader) Id() string {
 	return e.opts.Id
 }
 
-func (e *etcdLeader) Primary() (*sync.Member, error) {
-	if e.err != nil {
-		return nil, e.err
+func (e *etcdLeader) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.elected
+}
+
+func (e *etcdLeader) Token() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.token
+}
+
+func (e *etcdLeader) OnElected(fn func()) {
+	f := &electedFunc{fn: fn}
+
+	e.mu.Lock()
+	e.onElected = append(e.onElected, f)
+	report := e.elected && f.report(e.term)
+	e.mu.Unlock()
+
+	if report {
+		fn()
 	}
+}
 
-	rsp, err := e.e.Leader(context.TODO())
+func (e *etcdLeader) OnDemoted(fn func()) {
+	e.mu.Lock()
+	e.onDemoted = append(e.onDemoted, fn)
+	e.mu.Unlock()
+}
+
+func (e *etcdLeader) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *etcdLeader) Primary() (*sync.Member, error) {
+	rsp, err := e.client.Get(context.TODO(), e.pfx+"/", clientv3.WithFirstCreate()...)
 	if err != nil {
 		return nil, err
 	}
+	if len(rsp.Kvs) == 0 {
+		return nil, cc.ErrElectionNoLeader
+	}
+
 	member := &sync.Member{}
 	_ = json.Unmarshal(rsp.Kvs[0].Value, member)
 	member.Role = sync.Primary
 	return member, nil
 }
 
-func (e *etcdLeader) campaign() {
-	member := &sync.Member{
-		Leader:    e.name,
-		Id:        e.opts.Id,
-		Namespace: e.opts.Namespace,
+// run campaigns until the leader resigns
+func (e *etcdLeader) run() {
+	defer close(e.done)
+
+	text, _ := json.Marshal(e.value(false))
+
+	for {
+		e.mu.Lock()
+		s, el := e.s, e.e
+		e.mu.Unlock()
+
+		if s == nil {
+			var err error
+			s, err = cc.NewSession(e.client, cc.WithTTL(int(e.opts.TTL)))
+			if err != nil {
+				e.setErr(err)
+				if !e.sleep() {
+					return
+				}
+				continue
+			}
+			el = cc.NewElection(s, e.pfx)
+
+			e.mu.Lock()
+			e.s, e.e = s, el
+			e.mu.Unlock()
+		}
+
+		if err := e.campaign(s, el, string(text)); err != nil {
+			e.reset(s)
+			if e.ctx.Err() != nil {
+				e.setErr(nil)
+				return
+			}
+			e.setErr(err)
+			if !e.sleep() {
+				return
+			}
+			continue
+		}
+
+		e.mu.Lock()
+		e.token = el.Rev()
+		e.mu.Unlock()
+		e.setElected(true)
+
+		select {
+		case <-e.ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(e.opts.TTL)*time.Second)
+			err := el.Resign(ctx)
+			cancel()
+			e.setErr(err)
+			e.reset(s)
+			e.setElected(false)
+			return
+		case <-s.Done():
+			// the session expired, campaign with a new one
+			e.reset(s)
+			e.setElected(false)
+		}
+	}
+}
+
+// campaign blocks until the member is elected, it gives up when the session is lost
+func (e *etcdLeader) campaign(s *cc.Session, el *cc.Election, val string) error {
+	ctx, cancel := context.WithCancel(e.ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-s.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := el.Campaign(ctx, val); err != nil {
+		return err
+	}
+
+	select {
+	case <-s.Done():
+		return cc.ErrSessionExpired
+	default:
+	}
+	return nil
+}
+
+func (e *etcdLeader) sleep() bool {
+	select {
+	case <-e.ctx.Done():
+		return false
+	case <-time.After(campaignRetry):
+		return true
+	}
+}
+
+// reset drops the session, the next campaign creates a new one
+func (e *etcdLeader) reset(s *cc.Session) {
+	_ = s.Close()
+
+	e.mu.Lock()
+	if e.s == s {
+		e.s, e.e = nil, nil
 	}
+	e.mu.Unlock()
+}
 
-	text, _ := json.Marshal(member)
+func (e *etcdLeader) setErr(err error) {
+	e.mu.Lock()
+	e.err = err
+	e.mu.Unlock()
+}
 
-	ctx := context.Background()
-	if err := e.e.Campaign(ctx, string(text)); err != nil {
-		e.err = err
+func (e *etcdLeader) setElected(elected bool) {
+	e.mu.Lock()
+	if e.elected == elected {
+		e.mu.Unlock()
 		return
 	}
-	key := path.Join(e.prefix, "primary", e.opts.Namespace)
-	_, _ = e.s.Client().Put(context.TODO(), key, e.opts.Id)
-	e.elected <- struct{}{}
+	e.elected = elected
+	var fns []func()
+	if elected {
+		e.term++
+		e.err = nil
+		for _, f := range e.onElected {
+			if f.report(e.term) {
+				fns = append(fns, f.fn)
+			}
+		}
+	} else {
+		e.token = 0
+		fns = append(fns, e.onDemoted...)
+	}
+	for _, ch := range e.status {
+		// a reader which missed the last change gets the current state
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- elected
+	}
+	e.mu.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
 }
 
+func (e *etcdLeader) value(resigned bool) *memberValue {
+	return &memberValue{
+		Member: sync.Member{
+			Leader:    e.name,
+			Id:        e.opts.Id,
+			Namespace: e.opts.Namespace,
+		},
+		Resigned: resigned,
+	}
+}
+
+// markResigned marks the key of the member as resigned
+func (e *etcdLeader) markResigned() {
+	e.mu.Lock()
+	s := e.s
+	e.mu.Unlock()
+	if s == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(e.opts.TTL)*time.Second)
+	defer cancel()
+
+	key := fmt.Sprintf("%s/%x", e.pfx, s.Lease())
+	text, _ := json.Marshal(e.value(true))
+	_, _ = e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), ">", 0)).
+		Then(clientv3.OpPut(key, string(text), clientv3.WithIgnoreLease())).
+		Commit()
+}
+
+// Resign leaves the election, the leadership is handed over when the member is elected.
 func (e *etcdLeader) Resign() error {
 	select {
-	case <-e.stop:
-		return nil
-	case <-e.elected:
+	case <-e.done:
 	default:
-		return nil
+		e.markResigned()
 	}
+	e.cancel()
+	<-e.done
 
-	close(e.stop)
-	return e.e.Resign(context.Background())
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ch := range e.status {
+		close(ch)
+	}
+	e.status = nil
+	return e.err
 }
 
 func (e *etcdLeader) Observe() chan sync.ObserveResult {
 	ch := make(chan sync.ObserveResult, 1)
-	ech := e.e.Observe(context.Background())
 
 	go func() {
+		defer close(ch)
+
+		var last []byte
 		for {
-			select {
-			case <-e.stop:
-				close(ch)
+			rsp, err := e.client.Get(e.ctx, e.pfx+"/", clientv3.WithFirstCreate()...)
+			if err != nil {
 				return
-			case r := <-ech:
+			}
+
+			if len(rsp.Kvs) > 0 && string(rsp.Kvs[0].Value) != string(last) {
+				last = rsp.Kvs[0].Value
 				v := &sync.Member{}
-				err := json.Unmarshal(r.Kvs[0].Value, &v)
-				if err == nil {
-					ch <- sync.ObserveResult{
-						Namespace: v.Namespace,
-						Id:        v.Id,
+				if err = json.Unmarshal(last, &v); err == nil {
+					select {
+					case ch <- sync.ObserveResult{Namespace: v.Namespace, Id: v.Id}:
+					case <-e.ctx.Done():
+						return
 					}
 				}
 			}
+
+			// wait for the next change of the election
+			ctx, cancel := context.WithCancel(e.ctx)
+			wrsp, ok := <-e.client.Watch(ctx, e.pfx+"/", clientv3.WithPrefix(), clientv3.WithRev(rsp.Header.Revision+1))
+			cancel()
+			if !ok || wrsp.Err() != nil {
+				return
+			}
 		}
 	}()
 
 	return ch
 }
 
+// Status returns a channel which receives true when the member is elected and
+// false when it is demoted, the channel is closed when the member resigns.
 func (e *etcdLeader) Status() chan bool {
 	ch := make(chan bool, 1)
-	ech := e.e.Observe(context.Background())
 
-	go func() {
-		for {
-			select {
-			case <-e.stop:
-				close(ch)
-				return
-			case r := <-ech:
-				v := &sync.Member{}
-				err := json.Unmarshal(r.Kvs[0].Value, &v)
-				if err == nil && v.Id == e.Id() {
-					ch <- true
-					close(ch)
-					return
-				}
-			}
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-		}
-	}()
+	select {
+	case <-e.done:
+		close(ch)
+		return ch
+	default:
+	}
 
+	if e.elected {
+		ch <- true
+	}
+	e.status = append(e.status, ch)
 	return ch
 }
@@ -0,0 +1,151 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	gosync "sync"
+	"time"
+
+	cc "go.etcd.io/etcd/client/v3/concurrency"
+)
+
+var (
+	// ErrLocked is returned by TryAcquire when the lock is held by another owner
+	ErrLocked = errors.New("locked by another owner")
+	// ErrLockLost is returned when the session of a lock expired
+	ErrLockLost = errors.New("lock lost")
+	// ErrLockReleased is returned when the lock is used after it was released
+	ErrLockReleased = errors.New("lock released")
+)
+
+// LockInfo describes a holder or a waiter of a lock
+type LockInfo struct {
+	Owner    string            `json:"owner"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Requested is the time the lock was requested
+	Requested time.Time `json:"requested"`
+	// Acquired is the time the lock was acquired, it is zero for waiters
+	Acquired time.Time `json:"acquired"`
+	// Key is the etcd key of the holder or waiter
+	Key string `json:"-"`
+	// Lease is the session lease of the holder or waiter
+	Lease int64 `json:"-"`
+	// Revision is the create revision of Key, waiters are served in its order
+	Revision int64 `json:"-"`
+}
+
+// LockState is the holder and the waiters of a lock
+type LockState struct {
+	Id string
+	// Holder is nil when the lock is free
+	Holder  *LockInfo
+	Waiters []*LockInfo
+}
+
+// Lock is the handle of a lock held in etcd
+type Lock struct {
+	e     *EtcdSync
+	id    string
+	owner string
+	s     *cc.Session
+	m     *cc.Mutex
+	token int64
+
+	mu       gosync.Mutex
+	count    int
+	released bool
+}
+
+// Id returns the id of the lock
+func (l *Lock) Id() string {
+	return l.id
+}
+
+// Owner returns the owner token of the lock
+func (l *Lock) Owner() string {
+	return l.owner
+}
+
+// Key returns the etcd key owned by the lock
+func (l *Lock) Key() string {
+	return l.m.Key()
+}
+
+// Token returns the fencing token of the lock, the tokens of later holders of
+// the lock are greater. It is the create revision of the owned key.
+func (l *Lock) Token() int64 {
+	return l.token
+}
+
+// Done is closed when the session of the lock is lost or the lock is released,
+// the holder must abort its critical section then.
+func (l *Lock) Done() <-chan struct{} {
+	return l.s.Done()
+}
+
+// Refresh renews the ttl of the lock and checks that it is still owned
+func (l *Lock) Refresh(ctx context.Context) error {
+	l.mu.Lock()
+	released := l.released
+	l.mu.Unlock()
+	if released {
+		return ErrLockReleased
+	}
+
+	client := l.s.Client()
+	if _, err := client.KeepAliveOnce(ctx, l.s.Lease()); err != nil {
+		return ErrLockLost
+	}
+
+	rsp, err := client.Txn(ctx).If(l.m.IsOwner()).Commit()
+	if err != nil {
+		return err
+	}
+	if !rsp.Succeeded {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Unlock releases the lock, a reentrant lock is released when every
+// acquisition of its owner is unlocked.
+func (l *Lock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	if l.released {
+		l.mu.Unlock()
+		return ErrLockReleased
+	}
+	l.count--
+	if l.count > 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.released = true
+	l.mu.Unlock()
+
+	l.e.mtx.Lock()
+	if v, ok := l.e.locks[l.id]; ok && v == l {
+		delete(l.e.locks, l.id)
+	}
+	l.e.mtx.Unlock()
+
+	defer l.s.Close()
+	return l.m.Unlock(ctx)
+}
+
+// reenter acquires the lock again for its owner
+func (l *Lock) reenter(owner string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.released || l.owner != owner {
+		return false
+	}
+	select {
+	case <-l.s.Done():
+		return false
+	default:
+	}
+	l.count++
+	return true
+}
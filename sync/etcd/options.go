@@ -0,0 +1,99 @@
+package etcd
+
+import "time"
+
+// LockOptions configures a lock acquired by Acquire
+type LockOptions struct {
+	// TTL of the session keeping the lock, the lock is released when the
+	// holder doesn't keep the session alive for TTL
+	TTL time.Duration
+	// Wait bounds the time to acquire the lock
+	Wait time.Duration
+	// Owner identifies the holder of the lock
+	Owner string
+	// Reentrant allows the owner to acquire the lock it holds again
+	Reentrant bool
+	// Metadata describes the owner to the lookups of the lock
+	Metadata map[string]string
+}
+
+type LockOption func(o *LockOptions)
+
+// LockTTL sets the lock ttl
+func LockTTL(t time.Duration) LockOption {
+	return func(o *LockOptions) {
+		o.TTL = t
+	}
+}
+
+// LockWait sets the wait time
+func LockWait(t time.Duration) LockOption {
+	return func(o *LockOptions) {
+		o.Wait = t
+	}
+}
+
+// LockOwner sets the owner of the lock
+func LockOwner(owner string) LockOption {
+	return func(o *LockOptions) {
+		o.Owner = owner
+	}
+}
+
+// LockReentrant makes the lock reentrant for its owner
+func LockReentrant() LockOption {
+	return func(o *LockOptions) {
+		o.Reentrant = true
+	}
+}
+
+// LockMetadata sets the metadata describing the owner of the lock
+func LockMetadata(md map[string]string) LockOption {
+	return func(o *LockOptions) {
+		o.Metadata = md
+	}
+}
+
+// ShardOptions configures a member of a shard group
+type ShardOptions struct {
+	// Id identifies the member in the group
+	Id string
+	// TTL of the session keeping the membership and the shards of the member
+	TTL time.Duration
+	// Strategy assigns the shards to the members
+	Strategy Strategy
+	// HandoffTimeout bounds the time a revoked shard is kept until it is
+	// released, zero waits for Release
+	HandoffTimeout time.Duration
+}
+
+type ShardOption func(o *ShardOptions)
+
+// ShardId sets the id of the member
+func ShardId(id string) ShardOption {
+	return func(o *ShardOptions) {
+		o.Id = id
+	}
+}
+
+// ShardTTL sets the ttl of the session of the member
+func ShardTTL(t time.Duration) ShardOption {
+	return func(o *ShardOptions) {
+		o.TTL = t
+	}
+}
+
+// ShardStrategy sets the assignment strategy, it must be the same for all
+// members of the group
+func ShardStrategy(s Strategy) ShardOption {
+	return func(o *ShardOptions) {
+		o.Strategy = s
+	}
+}
+
+// ShardHandoffTimeout releases revoked shards after the timeout
+func ShardHandoffTimeout(t time.Duration) ShardOption {
+	return func(o *ShardOptions) {
+		o.HandoffTimeout = t
+	}
+}
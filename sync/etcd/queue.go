@@ -0,0 +1,194 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vine-io/vine/lib/sync"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	cc "go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// Permit is a share of a RWMutex or a Semaphore held in etcd
+type Permit struct {
+	s   *cc.Session
+	key string
+	rev int64
+
+	mu       gosync.Mutex
+	released bool
+}
+
+// Key returns the etcd key owned by the permit
+func (p *Permit) Key() string {
+	return p.key
+}
+
+// Token returns the fencing token of the permit, it is the create revision
+// of the owned key.
+func (p *Permit) Token() int64 {
+	return p.rev
+}
+
+// Done is closed when the session of the permit is lost or the permit is released
+func (p *Permit) Done() <-chan struct{} {
+	return p.s.Done()
+}
+
+// Refresh renews the ttl of the permit and checks that it is still owned
+func (p *Permit) Refresh(ctx context.Context) error {
+	p.mu.Lock()
+	released := p.released
+	p.mu.Unlock()
+	if released {
+		return ErrLockReleased
+	}
+
+	client := p.s.Client()
+	if _, err := client.KeepAliveOnce(ctx, p.s.Lease()); err != nil {
+		return ErrLockLost
+	}
+
+	cmp := clientv3.Compare(clientv3.CreateRevision(p.key), "=", p.rev)
+	rsp, err := client.Txn(ctx).If(cmp).Commit()
+	if err != nil {
+		return err
+	}
+	if !rsp.Succeeded {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Release gives the permit back
+func (p *Permit) Release(ctx context.Context) error {
+	p.mu.Lock()
+	if p.released {
+		p.mu.Unlock()
+		return ErrLockReleased
+	}
+	p.released = true
+	p.mu.Unlock()
+
+	defer p.s.Close()
+	_, err := p.s.Client().Delete(ctx, p.key)
+	return err
+}
+
+// queueKey returns the etcd prefix of a primitive of the given kind
+func (e *EtcdSync) queueKey(kind, id string) string {
+	return path.Join(e.prefix, kind, strings.Replace(e.options.Prefix+id, "/", "-", -1))
+}
+
+// session returns a new session with the ttl of the options
+func (e *EtcdSync) session(options LockOptions) (*cc.Session, error) {
+	return newSession(e.client, options)
+}
+
+// newSession returns a new session of the client with the ttl of the options
+func newSession(client *clientv3.Client, options LockOptions) (*cc.Session, error) {
+	var sopts []cc.SessionOption
+	if options.TTL > 0 {
+		sopts = append(sopts, cc.WithTTL(int(options.TTL.Seconds())))
+	}
+	return cc.NewSession(client, sopts...)
+}
+
+// enqueue registers a session under pfx and waits until fewer than limit keys
+// under wait were created before it, waiters are served in order of creation.
+func (e *EtcdSync) enqueue(ctx context.Context, pfx, wait string, limit int64, opts ...LockOption) (*Permit, error) {
+	var options LockOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.Owner == "" {
+		options.Owner = uuid.New().String()
+	}
+
+	s, err := e.session(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Wait != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Wait)
+		defer cancel()
+	}
+
+	info := &LockInfo{
+		Owner:     options.Owner,
+		Metadata:  options.Metadata,
+		Requested: time.Now(),
+	}
+	key := fmt.Sprintf("%s/%x", pfx, s.Lease())
+	val, _ := json.Marshal(info)
+	rsp, err := e.client.Put(ctx, key, string(val), clientv3.WithLease(s.Lease()))
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	p := &Permit{s: s, key: key, rev: rsp.Header.Revision}
+	if err = e.waitQueue(ctx, s, wait, p.rev, limit); err != nil {
+		_, _ = e.client.Delete(context.Background(), key)
+		_ = s.Close()
+		if ctx.Err() != nil {
+			return nil, sync.ErrLockTimeout
+		}
+		return nil, err
+	}
+
+	info.Acquired = time.Now()
+	val, _ = json.Marshal(info)
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", p.rev)
+	put := clientv3.OpPut(key, string(val), clientv3.WithLease(s.Lease()))
+	txn, err := e.client.Txn(ctx).If(cmp).Then(put).Commit()
+	if err == nil && !txn.Succeeded {
+		err = ErrLockLost
+	}
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// waitQueue blocks until fewer than limit keys under pfx were created before rev
+func (e *EtcdSync) waitQueue(ctx context.Context, s *cc.Session, pfx string, rev, limit int64) error {
+	for {
+		// etcd ignores the revision filters of count only requests
+		rsp, err := e.client.Get(ctx, pfx, clientv3.WithPrefix(), clientv3.WithKeysOnly(),
+			clientv3.WithMaxCreateRev(rev-1), clientv3.WithLimit(limit))
+		if err != nil {
+			return err
+		}
+		if int64(len(rsp.Kvs)) < limit {
+			return nil
+		}
+
+		wctx, cancel := context.WithCancel(ctx)
+		wch := e.client.Watch(wctx, pfx, clientv3.WithPrefix(), clientv3.WithFilterPut(), clientv3.WithRev(rsp.Header.Revision+1))
+		select {
+		case <-ctx.Done():
+			cancel()
+			return ctx.Err()
+		case <-s.Done():
+			cancel()
+			return ErrLockLost
+		case wrsp, ok := <-wch:
+			cancel()
+			if ok && wrsp.Err() != nil {
+				return wrsp.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package etcd
+
+import "context"
+
+// RWMutex is a reader/writer lock in etcd. Readers and writers are served in
+// order of arrival, a reader waits for the writers which arrived before it.
+type RWMutex struct {
+	e   *EtcdSync
+	pfx string
+}
+
+// NewRWMutex returns the RWMutex of the id
+func (e *EtcdSync) NewRWMutex(id string) *RWMutex {
+	return &RWMutex{e: e, pfx: e.queueKey("rwmutex", id)}
+}
+
+// RLock acquires a shared read lock
+func (rw *RWMutex) RLock(ctx context.Context, opts ...LockOption) (*Permit, error) {
+	return rw.e.enqueue(ctx, rw.pfx+"/read", rw.pfx+"/write/", 1, opts...)
+}
+
+// Lock acquires the exclusive write lock
+func (rw *RWMutex) Lock(ctx context.Context, opts ...LockOption) (*Permit, error) {
+	return rw.e.enqueue(ctx, rw.pfx+"/write", rw.pfx+"/", 1, opts...)
+}
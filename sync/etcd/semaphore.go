@@ -0,0 +1,27 @@
+package etcd
+
+import (
+	"context"
+	"math"
+)
+
+// Semaphore is a counting semaphore in etcd which admits up to n holders,
+// waiters are served in order of arrival.
+type Semaphore struct {
+	e   *EtcdSync
+	pfx string
+	n   int64
+}
+
+// NewSemaphore returns the Semaphore of the id with n permits
+func (e *EtcdSync) NewSemaphore(id string, n int) *Semaphore {
+	if n <= 0 {
+		n = math.MaxInt32
+	}
+	return &Semaphore{e: e, pfx: e.queueKey("semaphore", id), n: int64(n)}
+}
+
+// Acquire acquires one of the permits of the semaphore
+func (s *Semaphore) Acquire(ctx context.Context, opts ...LockOption) (*Permit, error) {
+	return s.e.enqueue(ctx, s.pfx, s.pfx+"/", s.n, opts...)
+}
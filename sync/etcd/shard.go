@@ -0,0 +1,383 @@
+package etcd
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/google/uuid"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	cc "go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// Assignment is the shard set of a member
+type Assignment struct {
+	// Shards are owned by the member
+	Shards []int
+	// Revoked are assigned to other members, the member keeps them until it
+	// stopped working on them and released them
+	Revoked []int
+
+	c *Coordinator
+}
+
+// Release hands the revoked shards over to their new owners
+func (a *Assignment) Release() {
+	if len(a.Revoked) == 0 {
+		return
+	}
+	select {
+	case a.c.releases <- a.Revoked:
+	case <-a.c.done:
+	}
+}
+
+// Coordinator splits the shards of a group between its live members. Every
+// member owns the key of its shards, a shard moves to a new owner only after
+// the former owner released it or lost its session.
+type Coordinator struct {
+	e      *EtcdSync
+	pfx    string
+	shards int
+	opts   ShardOptions
+	s      *cc.Session
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	ch       chan *Assignment
+	releases chan []int
+
+	mu      gosync.Mutex
+	members []string
+	err     error
+
+	// owners are the holders of the shard keys
+	owners  map[int]string
+	owned   map[int]bool
+	revoked map[int]time.Time
+}
+
+// NewCoordinator joins the shard group as a member, the shard set of the
+// member is sent on Assignments whenever it changes.
+func (e *EtcdSync) NewCoordinator(ctx context.Context, group string, shards int, opts ...ShardOption) (*Coordinator, error) {
+	var options ShardOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.Id == "" {
+		options.Id = uuid.New().String()
+	}
+	if options.Strategy == nil {
+		options.Strategy = Rendezvous()
+	}
+
+	s, err := e.session(LockOptions{TTL: options.TTL})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Coordinator{
+		e:        e,
+		pfx:      e.queueKey("shards", group),
+		shards:   shards,
+		opts:     options,
+		s:        s,
+		done:     make(chan struct{}),
+		ch:       make(chan *Assignment, 1),
+		releases: make(chan []int),
+		owned:    make(map[int]bool),
+		revoked:  make(map[int]time.Time),
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	if _, err = e.client.Put(ctx, c.memberKey(options.Id), options.Id, clientv3.WithLease(s.Lease())); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	go c.run()
+
+	return c, nil
+}
+
+func (c *Coordinator) memberKey(id string) string {
+	return path.Join(c.pfx, "members", id)
+}
+
+func (c *Coordinator) shardKey(shard int) string {
+	return path.Join(c.pfx, "owners", strconv.Itoa(shard))
+}
+
+// Id returns the id of the member
+func (c *Coordinator) Id() string {
+	return c.opts.Id
+}
+
+// Assignments returns the channel of the shard sets, a pending shard set is
+// replaced by the newer one. The channel is closed when the member left.
+func (c *Coordinator) Assignments() <-chan *Assignment {
+	return c.ch
+}
+
+// Members returns the live members of the group
+func (c *Coordinator) Members() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.members...)
+}
+
+// Err returns ErrLockLost when the session of the member was lost
+func (c *Coordinator) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close leaves the group, the shards of the member are released at once
+func (c *Coordinator) Close() error {
+	c.cancel()
+	<-c.done
+	return c.s.Close()
+}
+
+func (c *Coordinator) run() {
+	defer close(c.done)
+	defer close(c.ch)
+
+	for {
+		rev, err := c.list()
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-c.s.Done():
+				c.lost()
+				return
+			case <-time.After(campaignRetry):
+				continue
+			}
+		}
+		c.step()
+
+		if !c.watch(rev) {
+			return
+		}
+	}
+}
+
+// watch applies the changes of the group after rev, it returns false when the
+// member left and true when the group has to be read again.
+func (c *Coordinator) watch(rev int64) bool {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	wch := c.e.client.Watch(ctx, c.pfx+"/", clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+	for {
+		var timeout <-chan time.Time
+		if d, ok := c.nextHandoff(); ok {
+			timeout = time.After(d)
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return false
+		case <-c.s.Done():
+			c.lost()
+			return false
+		case shards := <-c.releases:
+			c.release(shards)
+		case <-timeout:
+			var expired []int
+			now := time.Now()
+			for shard, at := range c.revoked {
+				if !at.IsZero() && !at.After(now) {
+					expired = append(expired, shard)
+				}
+			}
+			c.release(expired)
+		case wrsp, ok := <-wch:
+			if !ok || wrsp.Err() != nil {
+				return true
+			}
+			for _, ev := range wrsp.Events {
+				c.apply(ev.Type == clientv3.EventTypeDelete, string(ev.Kv.Key), string(ev.Kv.Value))
+			}
+		}
+		c.step()
+	}
+}
+
+// list reads the members and the shard owners of the group
+func (c *Coordinator) list() (int64, error) {
+	rsp, err := c.e.client.Get(c.ctx, c.pfx+"/", clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.members = nil
+	c.mu.Unlock()
+	c.owners = make(map[int]string)
+	for _, kv := range rsp.Kvs {
+		c.apply(false, string(kv.Key), string(kv.Value))
+	}
+	return rsp.Header.Revision, nil
+}
+
+// apply records a change of a member or a shard key
+func (c *Coordinator) apply(deleted bool, key, val string) {
+	rel := strings.TrimPrefix(key, c.pfx+"/")
+	switch {
+	case strings.HasPrefix(rel, "members/"):
+		id := strings.TrimPrefix(rel, "members/")
+		c.mu.Lock()
+		i := sort.SearchStrings(c.members, id)
+		exists := i < len(c.members) && c.members[i] == id
+		if deleted && exists {
+			c.members = append(c.members[:i], c.members[i+1:]...)
+		} else if !deleted && !exists {
+			c.members = append(c.members[:i], append([]string{id}, c.members[i:]...)...)
+		}
+		c.mu.Unlock()
+	case strings.HasPrefix(rel, "owners/"):
+		shard, err := strconv.Atoi(strings.TrimPrefix(rel, "owners/"))
+		if err != nil {
+			return
+		}
+		if deleted {
+			delete(c.owners, shard)
+		} else {
+			c.owners[shard] = val
+		}
+	}
+}
+
+// step revokes the shards assigned to other members and takes the free shards
+// assigned to the member
+func (c *Coordinator) step() {
+	id := c.opts.Id
+	owners := c.opts.Strategy.Assign(c.shards, c.Members())
+
+	changed := false
+	for shard := range c.owned {
+		_, revoked := c.revoked[shard]
+		if owners[shard] != id && !revoked {
+			var at time.Time
+			if c.opts.HandoffTimeout > 0 {
+				at = time.Now().Add(c.opts.HandoffTimeout)
+			}
+			c.revoked[shard] = at
+			changed = true
+		} else if owners[shard] == id && revoked {
+			// assigned back before it was released
+			delete(c.revoked, shard)
+			changed = true
+		}
+	}
+
+	for shard, owner := range owners {
+		if owner != id || c.owned[shard] {
+			continue
+		}
+		if _, ok := c.owners[shard]; ok {
+			// wait until the former owner released it
+			continue
+		}
+
+		key := c.shardKey(shard)
+		rsp, err := c.e.client.Txn(c.ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, id, clientv3.WithLease(c.s.Lease()))).
+			Commit()
+		if err != nil || !rsp.Succeeded {
+			continue
+		}
+		c.owners[shard] = id
+		c.owned[shard] = true
+		changed = true
+	}
+
+	if changed {
+		c.emit()
+	}
+}
+
+// release deletes the keys of the revoked shards
+func (c *Coordinator) release(shards []int) {
+	changed := false
+	for _, shard := range shards {
+		if _, ok := c.revoked[shard]; !ok {
+			continue
+		}
+
+		key := c.shardKey(shard)
+		_, err := c.e.client.Txn(c.ctx).
+			If(clientv3.Compare(clientv3.LeaseValue(key), "=", c.s.Lease())).
+			Then(clientv3.OpDelete(key)).
+			Commit()
+		if err != nil {
+			continue
+		}
+		delete(c.revoked, shard)
+		delete(c.owned, shard)
+		changed = true
+	}
+
+	if changed {
+		c.emit()
+	}
+}
+
+// nextHandoff returns the time until the next revoked shard is released
+func (c *Coordinator) nextHandoff() (time.Duration, bool) {
+	var next time.Time
+	for _, at := range c.revoked {
+		if !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	return time.Until(next), true
+}
+
+// lost drops all shards when the session of the member is lost
+func (c *Coordinator) lost() {
+	c.mu.Lock()
+	c.err = ErrLockLost
+	c.mu.Unlock()
+
+	c.owned = make(map[int]bool)
+	c.revoked = make(map[int]time.Time)
+	c.emit()
+}
+
+// emit replaces the pending shard set by the current one
+func (c *Coordinator) emit() {
+	a := &Assignment{c: c}
+	for shard := range c.owned {
+		if _, ok := c.revoked[shard]; ok {
+			a.Revoked = append(a.Revoked, shard)
+		} else {
+			a.Shards = append(a.Shards, shard)
+		}
+	}
+	sort.Ints(a.Shards)
+	sort.Ints(a.Revoked)
+
+	select {
+	case <-c.ch:
+	default:
+	}
+	c.ch <- a
+}
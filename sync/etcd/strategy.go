@@ -0,0 +1,95 @@
+package etcd
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Strategy assigns shards to members. It must be deterministic, every member
+// computes the assignment of the whole group on its own.
+type Strategy interface {
+	// Assign returns the owner of every shard, members are sorted
+	Assign(shards int, members []string) []string
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	// fnv spreads similar keys poorly, finish with a mix of splitmix64
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+type rendezvous struct{}
+
+// Rendezvous assigns a shard to the member with the highest hash of the pair,
+// only the shards of a leaving member or taken by a joining member move.
+func Rendezvous() Strategy {
+	return rendezvous{}
+}
+
+func (rendezvous) Assign(shards int, members []string) []string {
+	owners := make([]string, shards)
+	for shard := range owners {
+		var max uint64
+		key := strconv.Itoa(shard)
+		for _, m := range members {
+			if h := hash(m + "/" + key); owners[shard] == "" || h > max {
+				owners[shard], max = m, h
+			}
+		}
+	}
+	return owners
+}
+
+type consistentHash struct {
+	replicas int
+}
+
+// ConsistentHash places replicas points of every member on a hash ring, a
+// shard belongs to the member of the next point on the ring.
+func ConsistentHash(replicas int) Strategy {
+	if replicas <= 0 {
+		replicas = 100
+	}
+	return &consistentHash{replicas: replicas}
+}
+
+func (c *consistentHash) Assign(shards int, members []string) []string {
+	owners := make([]string, shards)
+	if len(members) == 0 {
+		return owners
+	}
+
+	type point struct {
+		h      uint64
+		member string
+	}
+	ring := make([]point, 0, len(members)*c.replicas)
+	for _, m := range members {
+		for i := 0; i < c.replicas; i++ {
+			ring = append(ring, point{hash(m + "#" + strconv.Itoa(i)), m})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].h < ring[j].h
+	})
+
+	for shard := range owners {
+		h := hash("shard/" + strconv.Itoa(shard))
+		i := sort.Search(len(ring), func(i int) bool {
+			return ring[i].h >= h
+		})
+		if i == len(ring) {
+			i = 0
+		}
+		owners[shard] = ring[i].member
+	}
+	return owners
+}
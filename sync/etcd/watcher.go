@@ -7,61 +7,250 @@ import (
 	"path"
 
 	"github.com/vine-io/vine/lib/sync"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
+// ErrWatcherClosed is returned by Next when the watcher is closed
+var ErrWatcherClosed = errors.New("watcher closed")
+
+// EventType is the role transition of a member
+type EventType string
+
+const (
+	// EventJoined is sent when a member joins an election
+	EventJoined EventType = "joined"
+	// EventElected is sent when a member becomes the leader of its election
+	EventElected EventType = "elected"
+	// EventResigned is sent when a member leaves its election
+	EventResigned EventType = "resigned"
+	// EventExpired is sent when the session of a member is lost
+	EventExpired EventType = "expired"
+)
+
+// Event is a role transition of a member of an election
+type Event struct {
+	Type   EventType
+	Member *sync.Member
+	// Revision is the etcd revision of the transition
+	Revision int64
+}
+
+// ElectWatcher is the etcd implementation of sync.ElectWatcher, Next returns
+// the member of the next event.
+type ElectWatcher interface {
+	sync.ElectWatcher
+	// NextEvent blocks until the next role transition
+	NextEvent() (*Event, error)
+}
+
+// candidate is a member known to the watcher
+type candidate struct {
+	key      string
+	rev      int64
+	member   *sync.Member
+	resigned bool
+}
+
 type etcdWatcher struct {
-	stop   chan struct{}
-	w      clientv3.WatchChan
 	client *clientv3.Client
+	opts   sync.WatchElectOptions
+	key    string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	w       clientv3.WatchChan
+	rev     int64
+	members map[string]*candidate
+	pending []*Event
 }
 
-func newEtcdWatcher(e *EtcdSync, opts ...sync.WatchElectOption) (sync.ElectWatcher, error) {
+func newEtcdWatcher(ctx context.Context, e *EtcdSync, opts ...sync.WatchElectOption) (*etcdWatcher, error) {
 	var wo sync.WatchElectOptions
 	for _, o := range opts {
 		o(&wo)
 	}
 
-	stop := make(chan struct{}, 1)
+	ew := &etcdWatcher{
+		client:  e.client,
+		opts:    wo,
+		key:     path.Join(e.prefix, "leaders", wo.Namespace) + "/",
+		members: make(map[string]*candidate),
+	}
+	ew.ctx, ew.cancel = context.WithCancel(ctx)
 
-	key := path.Join(e.prefix, "leaders", wo.Namespace)
-	watchChan := e.client.Watch(context.TODO(), key, clientv3.WithPrefix())
+	if err := ew.sync(false); err != nil {
+		ew.cancel()
+		return nil, err
+	}
 
-	return &etcdWatcher{
-		stop: stop,
-		w:    watchChan,
-	}, nil
+	return ew, nil
 }
 
-func (ew *etcdWatcher) Next() (*sync.Member, error) {
-	for wresp := range ew.w {
-		if wresp.Err() != nil {
-			return nil, wresp.Err()
+// sync reads the members and watches from the read revision, the changes
+// since the last known state are reported when report is set.
+func (ew *etcdWatcher) sync(report bool) error {
+	rsp, err := ew.client.Get(ew.ctx, ew.key, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	ew.rev = rsp.Header.Revision
+
+	seen := make(map[string]bool, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		seen[string(kv.Key)] = true
+		ew.put(kv, report)
+	}
+	for key := range ew.members {
+		if !seen[key] {
+			ew.delete(key, ew.rev, report)
 		}
-		if wresp.Canceled {
-			return nil, errors.New("could not get next, watch is canceled")
+	}
+
+	ew.watch()
+	return nil
+}
+
+func (ew *etcdWatcher) watch() {
+	ew.w = ew.client.Watch(ew.ctx, ew.key, clientv3.WithPrefix(), clientv3.WithRev(ew.rev+1))
+}
+
+// leader returns the oldest member of the election of the key
+func (ew *etcdWatcher) leader(key string) *candidate {
+	var leader *candidate
+	dir := path.Dir(key)
+	for _, c := range ew.members {
+		if path.Dir(c.key) == dir && (leader == nil || c.rev < leader.rev) {
+			leader = c
 		}
-		for _, ev := range wresp.Events {
-			if ev.Type == clientv3.EventTypeDelete {
+	}
+	return leader
+}
+
+func (ew *etcdWatcher) emit(typ EventType, c *candidate, rev int64) {
+	if ew.opts.Id != "" && c.member.Id != ew.opts.Id {
+		return
+	}
+
+	member := *c.member
+	member.Role = sync.Follow
+	if typ == EventElected {
+		member.Role = sync.Primary
+	}
+	if typ == EventResigned || typ == EventExpired {
+		if leader := ew.leader(c.key); leader == c {
+			member.Role = sync.Primary
+		}
+	}
+	ew.pending = append(ew.pending, &Event{Type: typ, Member: &member, Revision: rev})
+}
+
+func (ew *etcdWatcher) put(kv *mvccpb.KeyValue, report bool) {
+	key := string(kv.Key)
+	v := &memberValue{}
+	if err := json.Unmarshal(kv.Value, v); err != nil {
+		return
+	}
+
+	c, ok := ew.members[key]
+	if !ok {
+		c = &candidate{key: key, rev: kv.CreateRevision, member: &v.Member}
+		ew.members[key] = c
+		if report {
+			ew.emit(EventJoined, c, kv.ModRevision)
+			if ew.leader(key) == c {
+				ew.emit(EventElected, c, kv.ModRevision)
+			}
+		}
+	}
+
+	if v.Resigned && !c.resigned {
+		c.resigned = true
+		if report {
+			ew.emit(EventResigned, c, kv.ModRevision)
+		}
+	}
+}
+
+func (ew *etcdWatcher) delete(key string, rev int64, report bool) {
+	c, ok := ew.members[key]
+	if !ok {
+		return
+	}
+
+	elected := ew.leader(key) == c
+	if report && !c.resigned {
+		ew.emit(EventExpired, c, rev)
+	}
+	delete(ew.members, key)
+
+	if elected && report {
+		if leader := ew.leader(key); leader != nil {
+			ew.emit(EventElected, leader, rev)
+		}
+	}
+}
+
+// NextEvent blocks until the next role transition. The watch is resumed from
+// the last revision when it breaks, and the members are read again when the
+// revision was compacted.
+func (ew *etcdWatcher) NextEvent() (*Event, error) {
+	for len(ew.pending) == 0 {
+		select {
+		case <-ew.ctx.Done():
+			return nil, ErrWatcherClosed
+		case wrsp, ok := <-ew.w:
+			if !ok {
+				if ew.ctx.Err() != nil {
+					return nil, ErrWatcherClosed
+				}
+				ew.watch()
+				continue
+			}
+
+			if wrsp.CompactRevision != 0 || wrsp.Err() == rpctypes.ErrCompacted {
+				if err := ew.sync(true); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := wrsp.Err(); err != nil {
+				if ew.ctx.Err() != nil {
+					return nil, ErrWatcherClosed
+				}
+				ew.watch()
 				continue
 			}
-			member := &sync.Member{}
-			err := json.Unmarshal(ev.Kv.Value, &member)
-			if err != nil {
-				return nil, err
+
+			for _, ev := range wrsp.Events {
+				if ev.Type == clientv3.EventTypeDelete {
+					ew.delete(string(ev.Kv.Key), ev.Kv.ModRevision, true)
+				} else {
+					ew.put(ev.Kv, true)
+				}
+				ew.rev = ev.Kv.ModRevision
 			}
-			return member, nil
 		}
 	}
 
-	return nil, errors.New("could not get next")
+	ev := ew.pending[0]
+	ew.pending = ew.pending[1:]
+	return ev, nil
 }
 
-func (ew *etcdWatcher) Close() {
-	select {
-	case <-ew.stop:
-		return
-	default:
-		close(ew.stop)
+// Next returns the member of the next role transition, its role is the role
+// after the transition or the role it held when it left.
+func (ew *etcdWatcher) Next() (*sync.Member, error) {
+	ev, err := ew.NextEvent()
+	if err != nil {
+		return nil, err
 	}
+	return ev.Member, nil
+}
+
+// Close stops the watch
+func (ew *etcdWatcher) Close() {
+	ew.cancel()
 }
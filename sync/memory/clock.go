@@ -0,0 +1,175 @@
+package memory
+
+import (
+	gosync "sync"
+	"time"
+)
+
+// Clock is the time source of the wait timeouts and the session expiry of
+// MemorySync
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	// AfterFunc calls f in its own goroutine once the duration elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer fires once on its channel, or calls its function
+type Timer interface {
+	// C returns nil for the timers of AfterFunc
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, it returns false when the timer
+	// already fired
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return &realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t *realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t *realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+type fakeTimer struct {
+	c  *FakeClock
+	at time.Time
+	ch chan time.Time
+	fn func()
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+
+	for i, v := range t.c.timers {
+		if v == t {
+			t.c.timers = append(t.c.timers[:i], t.c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// FakeClock is a Clock which only moves when it is advanced
+type FakeClock struct {
+	mu     gosync.Mutex
+	cond   *gosync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock returns a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = gosync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{c: c, at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// AfterFunc calls f when the clock is advanced past the duration, f runs in
+// the goroutine of Advance.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{c: c, at: c.now.Add(d), fn: f}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward and fires the timers which are due in the
+// order of their time. The clock is set to the time of each timer when it
+// fires, so the timers started by a function are measured from there and fire
+// in the same Advance when they are due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		next := -1
+		for i, t := range c.timers {
+			if t.at.After(end) {
+				continue
+			}
+			if next == -1 || t.at.Before(c.timers[next].at) {
+				next = i
+			}
+		}
+		if next == -1 {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+
+		t := c.timers[next]
+		c.timers = append(c.timers[:next], c.timers[next+1:]...)
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		now := c.now
+		c.mu.Unlock()
+
+		if t.fn != nil {
+			t.fn()
+		} else {
+			t.ch <- now
+		}
+	}
+}
+
+// BlockUntil blocks until n timers are pending, e.g. until n goroutines wait
+// for a lock with a wait time. The expiry of the held locks and sessions
+// counts as a pending timer too.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
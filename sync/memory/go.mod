@@ -2,9 +2,9 @@ module github.com/vine-io/plugins/sync/memory
 
 go 1.18
 
-require github.com/vine-io/vine v1.6.18
-
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/vine-io/vine v1.6.18
 )
+
+require github.com/spf13/pflag v1.0.5 // indirect
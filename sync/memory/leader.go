@@ -0,0 +1,143 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/vine-io/vine/lib/sync"
+)
+
+type memoryLeader struct {
+	m    *MemorySync
+	ns   string
+	name string
+	id   string
+	ttl  time.Duration
+	done chan struct{}
+
+	// guarded by m.mu
+	session   uint64
+	expiry    Timer
+	resigned  bool
+	status    []chan bool
+	observers []*queue
+}
+
+func (l *memoryLeader) member(primary bool) *sync.Member {
+	role := sync.Follow
+	if primary {
+		role = sync.Primary
+	}
+	return &sync.Member{
+		Leader:    l.name,
+		Id:        l.id,
+		Namespace: l.ns,
+		Role:      role,
+	}
+}
+
+// setStatus notifies the status channels, a reader which missed the last
+// change gets the current state. m.mu is held
+func (l *memoryLeader) setStatus(elected bool) {
+	for _, ch := range l.status {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- elected
+	}
+}
+
+func (l *memoryLeader) Id() string {
+	return l.id
+}
+
+// Resign leaves the election, the next member is elected
+func (l *memoryLeader) Resign() error {
+	m := l.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l.resigned {
+		return nil
+	}
+	l.resigned = true
+	m.leave(l)
+
+	for _, ch := range l.status {
+		close(ch)
+	}
+	l.status = nil
+	for _, q := range l.observers {
+		q.close()
+	}
+	l.observers = nil
+	close(l.done)
+	return nil
+}
+
+// Observe returns the current leader and every newly elected leader
+func (l *memoryLeader) Observe() chan sync.ObserveResult {
+	ch := make(chan sync.ObserveResult)
+	q := newQueue()
+
+	m := l.m
+	m.mu.Lock()
+	if l.resigned {
+		q.close()
+	} else {
+		if e, ok := m.elections[electionKey(l.ns, l.name)]; ok {
+			q.push(e.members[0].member(true))
+		}
+		l.observers = append(l.observers, q)
+	}
+	m.mu.Unlock()
+
+	go func() {
+		defer close(ch)
+		for {
+			member, ok := q.pop()
+			if !ok {
+				return
+			}
+			select {
+			case ch <- sync.ObserveResult{Namespace: member.Namespace, Id: member.Id}:
+			case <-q.done:
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+func (l *memoryLeader) Primary() (*sync.Member, error) {
+	m := l.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.elections[electionKey(l.ns, l.name)]
+	if !ok {
+		return nil, ErrNoLeader
+	}
+	return e.members[0].member(true), nil
+}
+
+// Status returns a channel which receives true when the member is elected and
+// false when it is demoted, the channel is closed when the member resigns.
+func (l *memoryLeader) Status() chan bool {
+	ch := make(chan bool, 1)
+
+	m := l.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l.resigned {
+		close(ch)
+		return ch
+	}
+	if e, ok := m.elections[electionKey(l.ns, l.name)]; ok && e.members[0] == l {
+		ch <- true
+	}
+	l.status = append(l.status, ch)
+	return ch
+}
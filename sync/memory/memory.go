@@ -20,14 +20,451 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-// Package memory provides a sync.Mutex implementation of the lock for local use
+// Package memory provides a deterministic in-memory implementation of sync.Sync
+// for local use and tests. Wait times and session expiry are measured by a
+// Clock which tests can replace by a FakeClock, and hooks expire sessions and
+// change leaders.
 package memory
 
 import (
+	"context"
+	"errors"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/vine-io/vine/lib/sync"
-	"github.com/vine-io/vine/lib/sync/memory"
 )
 
+var (
+	// ErrNoLeader is returned by Primary when the election has no member
+	ErrNoLeader = errors.New("election: no leader")
+	// ErrNotFound is returned by the hooks when the lock or member doesn't exist
+	ErrNotFound = errors.New("not found")
+)
+
+// MemorySync is the in-memory sync.Sync. A lock with a LockTTL is released and
+// a member with a LeaderTTL loses its session once the clock passes its ttl,
+// ExpireLock and ExpireMember simulate the loss of a session at any time.
+type MemorySync struct {
+	options sync.Options
+	clock   Clock
+
+	mu        gosync.Mutex
+	locks     map[string]*memoryLock
+	elections map[string]*election
+	watchers  map[*memoryWatcher]struct{}
+}
+
+type memoryLock struct {
+	held bool
+	// gen counts the holders, an expiry only releases the hold it was set for
+	gen    uint64
+	expiry Timer
+	// waiters are handed the lock in order of arrival
+	waiters []*lockWaiter
+}
+
+type lockWaiter struct {
+	ch  chan struct{}
+	ttl time.Duration
+}
+
+type election struct {
+	ns      string
+	name    string
+	members []*memoryLeader
+}
+
+func (m *MemorySync) Init(opts ...sync.Option) error {
+	for _, o := range opts {
+		o(&m.options)
+	}
+	return nil
+}
+
+func (m *MemorySync) Options() sync.Options {
+	return m.options
+}
+
+// Lock acquires the lock, the waiters are served in order of arrival. The wait
+// time and the ttl of the hold are measured by the clock.
+func (m *MemorySync) Lock(ctx context.Context, id string, opts ...sync.LockOption) error {
+	var options sync.LockOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	m.mu.Lock()
+	l, ok := m.locks[id]
+	if !ok {
+		l = &memoryLock{}
+		m.locks[id] = l
+	}
+	if !l.held {
+		m.hold(id, l, options.TTL)
+		m.mu.Unlock()
+		return nil
+	}
+	w := &lockWaiter{ch: make(chan struct{}), ttl: options.TTL}
+	l.waiters = append(l.waiters, w)
+	m.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if options.Wait > 0 {
+		t := m.clock.NewTimer(options.Wait)
+		defer t.Stop()
+		timeout = t.C()
+	}
+
+	select {
+	case <-w.ch:
+		return nil
+	case <-timeout:
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, v := range l.waiters {
+		if v == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return sync.ErrLockTimeout
+		}
+	}
+	// the lock was handed over meanwhile
+	return nil
+}
+
+func (m *MemorySync) Unlock(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.release(id)
+}
+
+// ExpireLock releases the lock as if the session of its holder was lost
+func (m *MemorySync) ExpireLock(id string) error {
+	return m.Unlock(context.Background(), id)
+}
+
+// hold gives the lock to a new holder and releases it once the ttl passed,
+// m.mu is held
+func (m *MemorySync) hold(id string, l *memoryLock, ttl time.Duration) {
+	l.held = true
+	l.gen++
+	if ttl <= 0 {
+		return
+	}
+
+	gen := l.gen
+	l.expiry = m.clock.AfterFunc(ttl, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.locks[id] == l && l.gen == gen {
+			_ = m.release(id)
+		}
+	})
+}
+
+// release hands the lock to the next waiter, m.mu is held
+func (m *MemorySync) release(id string) error {
+	l, ok := m.locks[id]
+	if !ok || !l.held {
+		return errors.New("lock not found")
+	}
+
+	if l.expiry != nil {
+		l.expiry.Stop()
+		l.expiry = nil
+	}
+	if len(l.waiters) > 0 {
+		w := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		close(w.ch)
+		m.hold(id, l, w.ttl)
+		return nil
+	}
+	delete(m.locks, id)
+	return nil
+}
+
+func (m *MemorySync) Leader(ctx context.Context, name string, opts ...sync.LeaderOption) (sync.Leader, error) {
+	var options sync.LeaderOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.Id == "" {
+		options.Id = uuid.New().String()
+	}
+	if options.Namespace == "" {
+		options.Namespace = "default"
+	}
+
+	l := &memoryLeader{
+		m:    m,
+		ns:   options.Namespace,
+		name: name,
+		id:   options.Id,
+		ttl:  time.Duration(options.TTL) * time.Second,
+		done: make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.join(l)
+	m.mu.Unlock()
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = l.Resign()
+			case <-l.done:
+			}
+		}()
+	}
+
+	return l, nil
+}
+
+// ListMembers returns the members of the elections in the namespace ordered
+// by election name, the first member of each election is its primary.
+func (m *MemorySync) ListMembers(ctx context.Context, opts ...sync.ListMembersOption) ([]*sync.Member, error) {
+	var options sync.ListMembersOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.Namespace == "" {
+		options.Namespace = "default"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var elections []*election
+	for _, e := range m.elections {
+		if e.ns == options.Namespace {
+			elections = append(elections, e)
+		}
+	}
+	sort.Slice(elections, func(i, j int) bool {
+		return elections[i].name < elections[j].name
+	})
+
+	members := make([]*sync.Member, 0)
+	for _, e := range elections {
+		for i, l := range e.members {
+			members = append(members, l.member(i == 0))
+		}
+	}
+	return members, nil
+}
+
+// WatchElect returns the members joining, leaving or being elected in the
+// namespace, or in all namespaces when none is given.
+func (m *MemorySync) WatchElect(ctx context.Context, opts ...sync.WatchElectOption) (sync.ElectWatcher, error) {
+	var options sync.WatchElectOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	w := &memoryWatcher{m: m, opts: options, q: newQueue()}
+
+	m.mu.Lock()
+	m.watchers[w] = struct{}{}
+	m.mu.Unlock()
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				w.Close()
+			case <-w.q.done:
+			}
+		}()
+	}
+
+	return w, nil
+}
+
+// ExpireMember removes the member from its election as if its session was
+// lost, the member joins the election again as the last candidate. A member
+// with a LeaderTTL does the same once the clock passes the ttl of its session.
+func (m *MemorySync) ExpireMember(ns, name, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.find(ns, name, id)
+	if l == nil {
+		return ErrNotFound
+	}
+	m.leave(l)
+	m.join(l)
+	return nil
+}
+
+// ForceLeader makes the member the leader of its election, the former leader
+// stays a candidate.
+func (m *MemorySync) ForceLeader(ns, name, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.find(ns, name, id)
+	if l == nil {
+		return ErrNotFound
+	}
+
+	e := m.elections[electionKey(ns, name)]
+	if e.members[0] == l {
+		return nil
+	}
+	former := e.members[0]
+	for i, v := range e.members {
+		if v == l {
+			e.members = append(e.members[:i], e.members[i+1:]...)
+			break
+		}
+	}
+	e.members = append([]*memoryLeader{l}, e.members...)
+
+	former.setStatus(false)
+	m.emit(former.member(false))
+	m.elect(e)
+	return nil
+}
+
+func electionKey(ns, name string) string {
+	return ns + "/" + name
+}
+
+// find returns the member of the election, m.mu is held
+func (m *MemorySync) find(ns, name, id string) *memoryLeader {
+	e, ok := m.elections[electionKey(ns, name)]
+	if !ok {
+		return nil
+	}
+	for _, l := range e.members {
+		if l.id == id {
+			return l
+		}
+	}
+	return nil
+}
+
+// join adds the member to its election with a new session, the session
+// expires once its ttl passed. m.mu is held
+func (m *MemorySync) join(l *memoryLeader) {
+	l.session++
+	if l.ttl > 0 {
+		session := l.session
+		l.expiry = m.clock.AfterFunc(l.ttl, func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if l.session == session && !l.resigned {
+				m.leave(l)
+				m.join(l)
+			}
+		})
+	}
+
+	key := electionKey(l.ns, l.name)
+	e, ok := m.elections[key]
+	if !ok {
+		e = &election{ns: l.ns, name: l.name}
+		m.elections[key] = e
+	}
+	e.members = append(e.members, l)
+
+	if len(e.members) == 1 {
+		m.elect(e)
+	} else {
+		m.emit(l.member(false))
+	}
+}
+
+// leave removes the member from its election and ends its session, m.mu is held
+func (m *MemorySync) leave(l *memoryLeader) {
+	if l.expiry != nil {
+		l.expiry.Stop()
+		l.expiry = nil
+	}
+
+	key := electionKey(l.ns, l.name)
+	e, ok := m.elections[key]
+	if !ok {
+		return
+	}
+
+	for i, v := range e.members {
+		if v != l {
+			continue
+		}
+		e.members = append(e.members[:i], e.members[i+1:]...)
+		m.emit(l.member(i == 0))
+		if i == 0 {
+			l.setStatus(false)
+			if len(e.members) > 0 {
+				m.elect(e)
+			}
+		}
+		break
+	}
+
+	if len(e.members) == 0 {
+		delete(m.elections, key)
+	}
+}
+
+// elect notifies the first member of the election of its leadership, m.mu is held
+func (m *MemorySync) elect(e *election) {
+	l := e.members[0]
+	l.setStatus(true)
+	member := l.member(true)
+	m.emit(member)
+	for _, v := range e.members {
+		for _, q := range v.observers {
+			q.push(member)
+		}
+	}
+}
+
+// emit sends the member to the watchers, m.mu is held
+func (m *MemorySync) emit(member *sync.Member) {
+	for w := range m.watchers {
+		if w.opts.Namespace != "" && w.opts.Namespace != member.Namespace {
+			continue
+		}
+		if w.opts.Id != "" && w.opts.Id != member.Id {
+			continue
+		}
+		v := *member
+		w.q.push(&v)
+	}
+}
+
+func (m *MemorySync) String() string {
+	return "memory"
+}
+
+// NewSync returns a MemorySync using the system clock
 func NewSync(opts ...sync.Option) sync.Sync {
-	return memory.NewSync()
+	return NewMemorySync(realClock{}, opts...)
+}
+
+// NewMemorySync returns a MemorySync measuring wait times with the clock
+func NewMemorySync(clock Clock, opts ...sync.Option) *MemorySync {
+	var options sync.Options
+	for _, o := range opts {
+		o(&options)
+	}
+
+	return &MemorySync{
+		options:   options,
+		clock:     clock,
+		locks:     make(map[string]*memoryLock),
+		elections: make(map[string]*election),
+		watchers:  make(map[*memoryWatcher]struct{}),
+	}
 }
@@ -0,0 +1,253 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vine-io/vine/lib/sync"
+)
+
+func TestMemorySync_Lock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := NewMemorySync(clock)
+
+	ctx := context.TODO()
+	if err := s.Lock(ctx, "lock"); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- s.Lock(ctx, "lock", sync.LockWait(time.Second))
+	}()
+	clock.BlockUntil(1)
+	go func() {
+		errs <- s.Lock(ctx, "lock", sync.LockWait(time.Second*3))
+	}()
+	clock.BlockUntil(2)
+
+	clock.Advance(time.Second)
+	if err := <-errs; err != sync.ErrLockTimeout {
+		t.Fatalf("expected lock timeout, got %v", err)
+	}
+
+	// the session of the holder is lost, the lock is handed to the waiter
+	if err := s.ExpireLock("lock"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("expected lock, got %v", err)
+	}
+
+	if err := s.Unlock(ctx, "lock"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unlock(ctx, "lock"); err == nil {
+		t.Fatal("unlock of a released lock")
+	}
+}
+
+func TestMemorySync_Leader(t *testing.T) {
+	s := NewMemorySync(NewFakeClock(time.Now()))
+
+	ctx := context.TODO()
+	w, err := s.WatchElect(ctx, sync.WatchNS("ns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	next := func(id string, role sync.Role) {
+		member, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if member.Id != id || member.Role != role {
+			t.Fatalf("expected %s %s, got %+v", id, role, member)
+		}
+	}
+
+	l1, err := s.Leader(ctx, "leader", sync.LeaderNS("ns"), sync.LeaderId("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2, err := s.Leader(ctx, "leader", sync.LeaderNS("ns"), sync.LeaderId("2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next("1", sync.Primary)
+	next("2", sync.Follow)
+
+	status := l1.Status()
+	if !<-status {
+		t.Fatal("expected leadership")
+	}
+	observe := l2.Observe()
+	if r := <-observe; r.Id != "1" {
+		t.Fatalf("unexpected leader %+v", r)
+	}
+
+	// the leader loses its session and joins again
+	if err = s.ExpireMember("ns", "leader", "1"); err != nil {
+		t.Fatal(err)
+	}
+	next("1", sync.Primary)
+	next("2", sync.Primary)
+	next("1", sync.Follow)
+	if <-status {
+		t.Fatal("expected demotion")
+	}
+	if r := <-observe; r.Id != "2" {
+		t.Fatalf("unexpected leader %+v", r)
+	}
+
+	members, err := s.ListMembers(ctx, sync.MemberNS("ns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].Id != "2" || members[0].Role != sync.Primary || members[1].Role != sync.Follow {
+		t.Fatalf("unexpected members %+v %+v", members[0], members[1])
+	}
+
+	if err = s.ForceLeader("ns", "leader", "1"); err != nil {
+		t.Fatal(err)
+	}
+	next("2", sync.Follow)
+	next("1", sync.Primary)
+	if !<-status {
+		t.Fatal("expected leadership")
+	}
+
+	if err = l1.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	next("1", sync.Primary)
+	next("2", sync.Primary)
+	if <-status {
+		t.Fatal("expected demotion")
+	}
+	if _, ok := <-status; ok {
+		t.Fatal("expected closed status")
+	}
+
+	p, err := l2.Primary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Id != "2" {
+		t.Fatalf("unexpected primary %+v", p)
+	}
+
+	_ = l2.Resign()
+	if _, err = l2.Primary(); err != ErrNoLeader {
+		t.Fatalf("expected no leader, got %v", err)
+	}
+}
+
+func TestMemorySync_Status(t *testing.T) {
+	s := NewMemorySync(NewFakeClock(time.Now()))
+
+	ctx := context.TODO()
+	l1, err := s.Leader(ctx, "leader", sync.LeaderNS("ns"), sync.LeaderId("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Leader(ctx, "leader", sync.LeaderNS("ns"), sync.LeaderId("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the demotion is not lost when the election was not read
+	status := l1.Status()
+	if err = s.ExpireMember("ns", "leader", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if <-status {
+		t.Fatal("expected demotion")
+	}
+}
+
+func TestMemorySync_LockTTL(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := NewMemorySync(clock)
+
+	ctx := context.TODO()
+	if err := s.Lock(ctx, "lock", sync.LockTTL(time.Second*2)); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Lock(ctx, "lock", sync.LockTTL(time.Second*2), sync.LockWait(time.Second*10))
+	}()
+	// the expiry of the holder and the wait of the waiter
+	clock.BlockUntil(2)
+
+	clock.Advance(time.Second)
+	select {
+	case err := <-errs:
+		t.Fatalf("lock acquired before the ttl passed: %v", err)
+	default:
+	}
+
+	// the ttl of the holder passed, the lock is handed to the waiter
+	clock.Advance(time.Second)
+	if err := <-errs; err != nil {
+		t.Fatalf("expected lock, got %v", err)
+	}
+
+	// the ttl of the new holder is measured from the handover
+	clock.Advance(time.Second * 2)
+	if err := s.Unlock(ctx, "lock"); err == nil {
+		t.Fatal("unlock of an expired lock")
+	}
+	if err := s.Lock(ctx, "lock", sync.LockWait(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemorySync_LeaderTTL(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := NewMemorySync(clock)
+
+	ctx := context.TODO()
+	l1, err := s.Leader(ctx, "leader", sync.LeaderNS("ns"), sync.LeaderId("1"), sync.LeaderTTL(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Leader(ctx, "leader", sync.LeaderNS("ns"), sync.LeaderId("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	status := l1.Status()
+	if !<-status {
+		t.Fatal("expected leadership")
+	}
+
+	clock.Advance(time.Second * 4)
+	if p, _ := l1.Primary(); p.Id != "1" {
+		t.Fatalf("leader demoted before the ttl passed: %+v", p)
+	}
+
+	// the session of the leader lapses, the next member is elected
+	clock.Advance(time.Second)
+	if <-status {
+		t.Fatal("expected demotion")
+	}
+	p, err := l1.Primary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Id != "2" {
+		t.Fatalf("unexpected primary %+v", p)
+	}
+
+	// a resigned member has no session left to expire
+	if err = l1.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second * 10)
+	if p, _ = l1.Primary(); p.Id != "2" {
+		t.Fatalf("unexpected primary %+v", p)
+	}
+}
@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"errors"
+	gosync "sync"
+
+	"github.com/vine-io/vine/lib/sync"
+)
+
+// queue is an unbounded queue of members, so that slow consumers don't block
+// the elections
+type queue struct {
+	mu     gosync.Mutex
+	items  []*sync.Member
+	notify chan struct{}
+	done   chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (q *queue) push(member *sync.Member) {
+	q.mu.Lock()
+	q.items = append(q.items, member)
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop blocks until a member is queued, it returns false when the queue is closed
+func (q *queue) pop() (*sync.Member, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			member := q.items[0]
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return member, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-q.done:
+			return nil, false
+		}
+	}
+}
+
+func (q *queue) close() {
+	select {
+	case <-q.done:
+	default:
+		close(q.done)
+	}
+}
+
+type memoryWatcher struct {
+	m    *MemorySync
+	opts sync.WatchElectOptions
+	q    *queue
+}
+
+// Next returns the next member joining, leaving or being elected, its role is
+// the role after joining or being elected and the role it held when leaving.
+func (w *memoryWatcher) Next() (*sync.Member, error) {
+	member, ok := w.q.pop()
+	if !ok {
+		return nil, errors.New("watcher stopped")
+	}
+	return member, nil
+}
+
+func (w *memoryWatcher) Close() {
+	w.m.mu.Lock()
+	delete(w.m.watchers, w)
+	w.m.mu.Unlock()
+	w.q.close()
+}
@@ -0,0 +1,323 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	gosync "sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vine-io/vine/lib/sync"
+)
+
+// ErrNoLeader is returned by Primary when nobody holds the leadership
+var ErrNoLeader = errors.New("election: no leader")
+
+type redisLeader struct {
+	r    *redisSync
+	opts sync.LeaderOptions
+	name string
+	// val is the member stored in the leader key while the member is elected
+	val string
+	ttl time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// expiry demotes the member when the leadership was not renewed in time
+	expiry *time.Timer
+
+	mu      gosync.Mutex
+	elected bool
+	renewed time.Time
+	err     error
+	status  []chan bool
+}
+
+func newRedisLeader(ctx context.Context, r *redisSync, name string, opts sync.LeaderOptions) (*redisLeader, error) {
+	member := &sync.Member{
+		Leader:    name,
+		Id:        opts.Id,
+		Namespace: opts.Namespace,
+	}
+	val, err := json.Marshal(member)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &redisLeader{
+		r:    r,
+		opts: opts,
+		name: name,
+		val:  string(val),
+		ttl:  time.Duration(opts.TTL) * time.Second,
+		done: make(chan struct{}),
+	}
+	l.ctx, l.cancel = context.WithCancel(ctx)
+	l.expiry = time.AfterFunc(l.ttl, l.expire)
+	l.expiry.Stop()
+
+	// register the member before returning, so that it is listed right away
+	if err = r.client.Set(ctx, l.memberKey(), l.val, l.ttl).Err(); err != nil {
+		l.cancel()
+		return nil, err
+	}
+
+	go l.run()
+
+	return l, nil
+}
+
+func (l *redisLeader) memberKey() string {
+	return l.r.memberKey(l.opts.Namespace, l.name, l.opts.Id)
+}
+
+func (l *redisLeader) leaderKey() string {
+	return l.r.leaderKey(l.opts.Namespace, l.name)
+}
+
+// lease returns how long the leadership is held after a renewal, it ends
+// before the leader key expires so that two members are never elected at once
+func (l *redisLeader) lease() time.Duration {
+	return l.ttl - l.ttl/5
+}
+
+// renew records the renewal of the leadership started at the time
+func (l *redisLeader) renew(start time.Time) {
+	l.mu.Lock()
+	l.renewed = start
+	l.mu.Unlock()
+	l.expiry.Reset(time.Until(start.Add(l.lease())))
+}
+
+// expire demotes the member when the lease of the last renewal ended
+func (l *redisLeader) expire() {
+	l.mu.Lock()
+	lost := l.elected && time.Since(l.renewed) >= l.lease()
+	l.mu.Unlock()
+
+	if lost {
+		l.setElected(false)
+	}
+}
+
+func (l *redisLeader) Id() string {
+	return l.opts.Id
+}
+
+// run campaigns every third of the ttl until the member resigns
+func (l *redisLeader) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		l.campaign()
+
+		select {
+		case <-l.ctx.Done():
+			l.leave()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign renews the membership and the leadership of the member, or takes
+// the leadership when the leader key expired.
+func (l *redisLeader) campaign() {
+	ctx := l.ctx
+	client := l.r.client
+
+	if err := client.Set(ctx, l.memberKey(), l.val, l.ttl).Err(); err != nil {
+		l.fail(err)
+		return
+	}
+
+	if l.IsLeader() {
+		start := time.Now()
+		n, err := renew.Run(ctx, client, []string{l.leaderKey()}, l.val, l.ttl.Milliseconds()).Int()
+		if err != nil {
+			l.fail(err)
+			return
+		}
+		if n == 1 {
+			l.renew(start)
+			return
+		}
+		// the leader key expired or was taken over
+		l.setElected(false)
+	}
+
+	start := time.Now()
+	ok, err := client.SetNX(ctx, l.leaderKey(), l.val, l.ttl).Result()
+	if err != nil {
+		l.fail(err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	l.renew(start)
+	l.setElected(true)
+
+	_ = client.Publish(ctx, l.r.channel(l.opts.Namespace), l.val).Err()
+}
+
+// fail records the error, the leadership is lost when it couldn't be renewed
+// within the lease.
+func (l *redisLeader) fail(err error) {
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+
+	l.expire()
+}
+
+// leave releases the leadership and the membership
+func (l *redisLeader) leave() {
+	l.expiry.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl)
+	defer cancel()
+
+	client := l.r.client
+	err := release.Run(ctx, client, []string{l.leaderKey()}, l.val).Err()
+	if e1 := client.Del(ctx, l.memberKey()).Err(); err == nil {
+		err = e1
+	}
+
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+	l.setElected(false)
+}
+
+func (l *redisLeader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.elected
+}
+
+func (l *redisLeader) setElected(elected bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.elected == elected {
+		return
+	}
+	l.elected = elected
+	for _, ch := range l.status {
+		// a reader which missed the last change gets the current state
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- elected
+	}
+}
+
+// Resign leaves the election, the leader key is released when the member holds it
+func (l *redisLeader) Resign() error {
+	l.cancel()
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ch := range l.status {
+		close(ch)
+	}
+	l.status = nil
+	return l.err
+}
+
+func (l *redisLeader) Primary() (*sync.Member, error) {
+	val, err := l.r.client.Get(context.TODO(), l.leaderKey()).Result()
+	if err == redis.Nil {
+		return nil, ErrNoLeader
+	} else if err != nil {
+		return nil, err
+	}
+
+	member := &sync.Member{}
+	if err = json.Unmarshal([]byte(val), member); err != nil {
+		return nil, err
+	}
+	member.Role = sync.Primary
+	return member, nil
+}
+
+// Observe returns the current leader and every newly elected leader
+func (l *redisLeader) Observe() chan sync.ObserveResult {
+	ch := make(chan sync.ObserveResult, 1)
+
+	// subscribe before reading the current leader to not miss an election
+	sub := l.r.client.Subscribe(l.ctx, l.r.channel(l.opts.Namespace))
+
+	go func() {
+		defer close(ch)
+		defer sub.Close()
+
+		send := func(val string) bool {
+			member := &sync.Member{}
+			if err := json.Unmarshal([]byte(val), member); err != nil || member.Leader != l.name {
+				return true
+			}
+			select {
+			case ch <- sync.ObserveResult{Namespace: member.Namespace, Id: member.Id}:
+				return true
+			case <-l.ctx.Done():
+				return false
+			}
+		}
+
+		if _, err := sub.Receive(l.ctx); err != nil {
+			return
+		}
+		if val, err := l.r.client.Get(l.ctx, l.leaderKey()).Result(); err == nil {
+			if !send(val) {
+				return
+			}
+		}
+
+		msgs := sub.Channel()
+		for {
+			select {
+			case <-l.ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok || !send(msg.Payload) {
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Status returns a channel which receives true when the member is elected and
+// false when it is demoted, the channel is closed when the member resigns.
+func (l *redisLeader) Status() chan bool {
+	ch := make(chan bool, 1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.done:
+		close(ch)
+		return ch
+	default:
+	}
+
+	if l.elected {
+		ch <- true
+	}
+	l.status = append(l.status, ch)
+	return ch
+}
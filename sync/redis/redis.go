@@ -0,0 +1,306 @@
+// Package redis provides a Redis implementation of sync.Sync. Locks are keys
+// set with SET NX PX holding the token of their owner, and the leader of an
+// election is the member which holds the leader key until it expires.
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/vine-io/vine/lib/sync"
+)
+
+var (
+	// DefaultLockTTL is the ttl of a lock acquired without LockTTL, the lock is
+	// renewed until it is released
+	DefaultLockTTL = time.Second * 30
+	// DefaultRetryInterval is the delay between two attempts to acquire a lock
+	DefaultRetryInterval = time.Millisecond * 50
+
+	// ErrLockLost is returned when the lock expired before it was released
+	ErrLockLost = errors.New("lock lost")
+)
+
+// release deletes the key if it is held by the token
+var release = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// renew extends the ttl of the key if it is held by the token
+var renew = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+type redisSync struct {
+	options sync.Options
+	prefix  string
+	client  *redis.Client
+
+	mtx   gosync.Mutex
+	locks map[string]*lock
+}
+
+type lock struct {
+	key   string
+	token string
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func (r *redisSync) configure() error {
+	nodes := r.options.Nodes
+	if len(nodes) == 0 {
+		nodes = []string{"redis://127.0.0.1:6379"}
+	}
+
+	redisOptions, err := redis.ParseURL(nodes[0])
+	if err != nil {
+		redisOptions = &redis.Options{
+			Addr: nodes[0],
+		}
+	}
+
+	r.prefix = r.options.Prefix
+	if r.prefix == "" {
+		r.prefix = "/vine/sync"
+	}
+
+	if r.client != nil {
+		_ = r.client.Close()
+	}
+	r.client = redis.NewClient(redisOptions)
+	return nil
+}
+
+func (r *redisSync) Init(opts ...sync.Option) error {
+	for _, o := range opts {
+		o(&r.options)
+	}
+
+	return r.configure()
+}
+
+func (r *redisSync) Options() sync.Options {
+	return r.options
+}
+
+func (r *redisSync) Leader(ctx context.Context, name string, opts ...sync.LeaderOption) (sync.Leader, error) {
+	var options sync.LeaderOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.Id == "" {
+		options.Id = uuid.New().String()
+	}
+	if options.TTL == 0 {
+		options.TTL = 30
+	}
+	if options.Namespace == "" {
+		options.Namespace = "default"
+	}
+
+	return newRedisLeader(ctx, r, name, options)
+}
+
+// ListMembers returns the members of the elections in the namespace, the
+// holders of the leader keys are primary.
+func (r *redisSync) ListMembers(ctx context.Context, opts ...sync.ListMembersOption) ([]*sync.Member, error) {
+	var options sync.ListMembersOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.Namespace == "" {
+		options.Namespace = "default"
+	}
+
+	var keys []string
+	iter := r.client.Scan(ctx, 0, r.memberKey(options.Namespace, "*", "*"), 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	members := make([]*sync.Member, 0, len(keys))
+	primaries := map[string]string{}
+	for _, key := range keys {
+		val, err := r.client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			// expired meanwhile
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		member := &sync.Member{}
+		if err = json.Unmarshal([]byte(val), member); err != nil {
+			continue
+		}
+
+		primary, ok := primaries[member.Leader]
+		if !ok {
+			primary, err = r.client.Get(ctx, r.leaderKey(options.Namespace, member.Leader)).Result()
+			if err != nil && err != redis.Nil {
+				return nil, err
+			}
+			primaries[member.Leader] = primary
+		}
+
+		member.Role = sync.Follow
+		if primary == val {
+			member.Role = sync.Primary
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+func (r *redisSync) WatchElect(ctx context.Context, opts ...sync.WatchElectOption) (sync.ElectWatcher, error) {
+	return newRedisWatcher(ctx, r, opts...)
+}
+
+// Lock acquires the lock, it retries until the lock is free or the wait time
+// elapsed. The lock is renewed in the background until it is released.
+func (r *redisSync) Lock(ctx context.Context, id string, opts ...sync.LockOption) error {
+	var options sync.LockOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if options.TTL <= 0 {
+		options.TTL = DefaultLockTTL
+	}
+	if options.Wait != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Wait)
+		defer cancel()
+	}
+
+	l := &lock{
+		key:   r.lockKey(id),
+		token: uuid.New().String(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	for {
+		ok, err := r.client.SetNX(ctx, l.key, l.token, options.TTL).Result()
+		if err != nil {
+			if ctx.Err() != nil {
+				return sync.ErrLockTimeout
+			}
+			return err
+		}
+		if ok {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return sync.ErrLockTimeout
+		case <-time.After(DefaultRetryInterval):
+		}
+	}
+
+	r.mtx.Lock()
+	r.locks[id] = l
+	r.mtx.Unlock()
+
+	go r.keepAlive(l, options.TTL)
+	return nil
+}
+
+// keepAlive renews the lock every third of its ttl until it is released or lost
+func (r *redisSync) keepAlive(l *lock, ttl time.Duration) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			n, err := renew.Run(context.Background(), r.client, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+			if err == nil && n == 0 {
+				return
+			}
+		}
+	}
+}
+
+// Unlock releases the lock acquired by Lock in this process
+func (r *redisSync) Unlock(ctx context.Context, id string) error {
+	r.mtx.Lock()
+	l, ok := r.locks[id]
+	delete(r.locks, id)
+	r.mtx.Unlock()
+	if !ok {
+		return errors.New("lock not found")
+	}
+
+	close(l.stop)
+	<-l.done
+
+	n, err := release.Run(ctx, r.client, []string{l.key}, l.token).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func (r *redisSync) lockKey(id string) string {
+	return path.Join(r.prefix, "lock", strings.Replace(id, "/", "-", -1))
+}
+
+func (r *redisSync) leaderKey(ns, name string) string {
+	return path.Join(r.prefix, "leaders", ns, name)
+}
+
+func (r *redisSync) memberKey(ns, name, id string) string {
+	return path.Join(r.prefix, "members", ns, name, id)
+}
+
+// channel is the pub/sub channel of the elections in the namespace
+func (r *redisSync) channel(ns string) string {
+	return path.Join(r.prefix, "events", ns)
+}
+
+func (r *redisSync) String() string {
+	return "redis"
+}
+
+func NewSync(opts ...sync.Option) sync.Sync {
+	var options sync.Options
+	for _, o := range opts {
+		o(&options)
+	}
+
+	r := &redisSync{
+		options: options,
+		locks:   make(map[string]*lock),
+	}
+	_ = r.configure()
+	return r
+}
@@ -0,0 +1,193 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/vine-io/vine/lib/sync"
+)
+
+func newTestSync(t *testing.T) (*miniredis.Miniredis, sync.Sync) {
+	m := miniredis.RunT(t)
+	s := NewSync(sync.Nodes(m.Addr()))
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return m, s
+}
+
+func TestRedisSync_Lock(t *testing.T) {
+	m, s := newTestSync(t)
+
+	ctx := context.TODO()
+	if err := s.Lock(ctx, "lock", sync.LockTTL(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := m.TTL(s.(*redisSync).lockKey("lock")); ttl != time.Second {
+		t.Fatalf("expected lock ttl 1s, got %v", ttl)
+	}
+
+	if err := s.Lock(ctx, "lock", sync.LockWait(time.Millisecond*100)); err != sync.ErrLockTimeout {
+		t.Fatalf("expected lock timeout, got %v", err)
+	}
+
+	if err := s.Unlock(ctx, "lock"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unlock(ctx, "lock"); err == nil {
+		t.Fatal("unlock of a released lock")
+	}
+
+	if err := s.Lock(ctx, "lock", sync.LockTTL(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock expired and was taken by another owner
+	m.FastForward(time.Second)
+	if err := m.Set(s.(*redisSync).lockKey("lock"), "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unlock(ctx, "lock"); err != ErrLockLost {
+		t.Fatalf("expected lost lock, got %v", err)
+	}
+	if v, _ := m.Get(s.(*redisSync).lockKey("lock")); v != "other" {
+		t.Fatalf("the lock of another owner was released")
+	}
+}
+
+func TestRedisSync_Leader(t *testing.T) {
+	m, s := newTestSync(t)
+
+	ctx := context.TODO()
+	w, err := s.WatchElect(ctx, sync.WatchNS("ns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	l1, err := s.Leader(ctx, "leader", sync.LeaderNS("ns"), sync.LeaderId("1"), sync.LeaderTTL(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := l1.Status()
+	if elected := <-status; !elected {
+		t.Fatal("expected leadership")
+	}
+
+	member, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.Id != "1" || member.Role != sync.Primary {
+		t.Fatalf("unexpected member %+v", member)
+	}
+
+	l2, err := s.Leader(ctx, "leader", sync.LeaderNS("ns"), sync.LeaderId("2"), sync.LeaderTTL(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Resign()
+
+	members, err := s.ListMembers(ctx, sync.MemberNS("ns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("expected 2 members, got %d", len(members))
+	}
+	for _, member := range members {
+		if (member.Id == "1") != (member.Role == sync.Primary) {
+			t.Fatalf("unexpected role of %+v", member)
+		}
+	}
+
+	observe := l2.Observe()
+	if r := <-observe; r.Id != "1" {
+		t.Fatalf("unexpected leader %+v", r)
+	}
+
+	// another process took the leader key over
+	if err = m.Set(s.(*redisSync).leaderKey("ns", "leader"), `{"id":"3"}`); err != nil {
+		t.Fatal(err)
+	}
+	if elected := <-status; elected {
+		t.Fatal("expected demotion")
+	}
+	m.Del(s.(*redisSync).leaderKey("ns", "leader"))
+
+	select {
+	case r := <-observe:
+		if r.Id == "" {
+			t.Fatalf("unexpected leader %+v", r)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("no leader elected")
+	}
+
+	if err = l1.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	// drain the pending transitions until the channel is closed
+	for range status {
+	}
+
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		p, err := l2.Primary()
+		if err == nil && p.Id == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("leadership not handed over: %v %v", p, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+func TestRedisLeader_Status(t *testing.T) {
+	l := &redisLeader{done: make(chan struct{})}
+	status := l.Status()
+
+	// the demotion is not lost when the election was not read
+	l.setElected(true)
+	l.setElected(false)
+	if <-status {
+		t.Fatal("expected demotion")
+	}
+}
+
+func TestRedisLeader_Expire(t *testing.T) {
+	m, s := newTestSync(t)
+
+	l, err := s.Leader(context.TODO(), "leader", sync.LeaderTTL(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Resign()
+
+	status := l.Status()
+	if elected := <-status; !elected {
+		t.Fatal("expected leadership")
+	}
+
+	// the renewals fail, the member is demoted before the leader key expires
+	m.Close()
+	select {
+	case elected := <-status:
+		if elected {
+			t.Fatal("expected demotion")
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("leadership not lost")
+	}
+
+	rl := l.(*redisLeader)
+	rl.mu.Lock()
+	renewed := rl.renewed
+	rl.mu.Unlock()
+	if d := time.Since(renewed); d >= rl.ttl {
+		t.Fatalf("demoted %v after the last renewal", d)
+	}
+}
@@ -0,0 +1,78 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vine-io/vine/lib/sync"
+)
+
+type redisWatcher struct {
+	opts sync.WatchElectOptions
+	sub  *redis.PubSub
+	ch   <-chan *redis.Message
+	stop chan struct{}
+}
+
+// newRedisWatcher subscribes to the elections of the namespace, or of all
+// namespaces when none is given.
+func newRedisWatcher(ctx context.Context, r *redisSync, opts ...sync.WatchElectOption) (sync.ElectWatcher, error) {
+	var wo sync.WatchElectOptions
+	for _, o := range opts {
+		o(&wo)
+	}
+
+	var sub *redis.PubSub
+	if wo.Namespace == "" {
+		sub = r.client.PSubscribe(ctx, r.channel("*"))
+	} else {
+		sub = r.client.Subscribe(ctx, r.channel(wo.Namespace))
+	}
+	if _, err := sub.Receive(ctx); err != nil {
+		_ = sub.Close()
+		return nil, err
+	}
+
+	return &redisWatcher{
+		opts: wo,
+		sub:  sub,
+		ch:   sub.Channel(),
+		stop: make(chan struct{}),
+	}, nil
+}
+
+// Next returns the next elected leader
+func (w *redisWatcher) Next() (*sync.Member, error) {
+	for {
+		select {
+		case <-w.stop:
+			return nil, errors.New("watcher stopped")
+		case msg, ok := <-w.ch:
+			if !ok {
+				return nil, errors.New("could not get next")
+			}
+
+			member := &sync.Member{}
+			if err := json.Unmarshal([]byte(msg.Payload), member); err != nil {
+				return nil, err
+			}
+			if w.opts.Id != "" && member.Id != w.opts.Id {
+				continue
+			}
+			member.Role = sync.Primary
+			return member, nil
+		}
+	}
+}
+
+func (w *redisWatcher) Close() {
+	select {
+	case <-w.stop:
+		return
+	default:
+		close(w.stop)
+		_ = w.sub.Close()
+	}
+}